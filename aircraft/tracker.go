@@ -0,0 +1,333 @@
+// Package aircraft keeps track of the aircraft seen in a stream of beast
+// frames.
+package aircraft
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+)
+
+const (
+	// pairInterval is the maximum time between even and odd position reports
+	// for them to be decoded together.
+	pairInterval = 10 * time.Second
+
+	// referenceAge is how long a decoded position may be used as the reference
+	// for decoding subsequent single reports.
+	referenceAge = 10 * time.Minute
+
+	// rssiSamples is the number of signal levels averaged to give the RSSI.
+	rssiSamples = 8
+)
+
+// Aircraft is a snapshot of what is known about one aircraft. Fields are only
+// meaningful if the corresponding Has flag is set (or, for strings, if they
+// are not empty).
+type Aircraft struct {
+	ICAO     uint32
+	Callsign string
+	Category string
+	Squawk   string
+
+	HasPosition  bool
+	Lat, Lon     float64
+	PositionTime time.Time
+
+	HasAltitude bool
+	Altitude    int // Barometric, feet.
+
+	HasOnGround bool
+	OnGround    bool
+
+	HasVelocity bool
+	GroundSpeed float64 // Knots.
+	Track       float64 // Degrees.
+
+	HasVerticalRate bool
+	VerticalRate    int // Feet per minute.
+
+	LastSeen time.Time
+	Messages int
+	// RSSI is the average signal level of recent messages, in dBFS.
+	RSSI float64
+	// Remotes lists the sources that have heard the aircraft, sorted.
+	Remotes []string
+}
+
+type location struct {
+	lat, lon float64
+}
+
+type report struct {
+	cpr  modes.CPR
+	seen time.Time
+}
+
+type state struct {
+	Aircraft
+
+	even, odd report
+	remotes   map[string]time.Time
+	signals   [rssiSamples]float64
+	nSignals  int
+}
+
+// Tracker maintains the state of each aircraft, keyed by ICAO address. It is
+// safe for concurrent use.
+type Tracker struct {
+	expiry time.Duration
+	// reference is the receiver's location, if known, needed to decode the
+	// positions of aircraft on the ground.
+	reference *location
+
+	mu       sync.RWMutex
+	aircraft map[uint32]*state
+	messages uint64
+}
+
+// NewTracker returns a Tracker that forgets aircraft once nothing has been
+// heard from them for the expiry period.
+func NewTracker(expiry time.Duration) *Tracker {
+	return &Tracker{
+		expiry:   expiry,
+		aircraft: make(map[uint32]*state),
+	}
+}
+
+// SetReference gives the location of the receivers, which must be within
+// about 45 degrees of the aircraft. Without it, the positions of aircraft on
+// the ground are only known if they were first seen in the air, and are
+// decoded from their last airborne positions.
+func (t *Tracker) SetReference(lat, lon float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reference = &location{lat: lat, lon: lon}
+}
+
+// Update applies a frame received from the named remote at the given time.
+func (t *Tracker) Update(remote string, f beast.Frame, now time.Time) {
+	if f.Type != beast.ModeSShort && f.Type != beast.ModeSLong {
+		return
+	}
+
+	m, ok := modes.Decode(f.Data)
+	if !ok {
+		return
+	}
+
+	verified := modes.CheckParity(f.Data)
+	if df := m.DF; !verified && (df == 11 || df == 17 || df == 18) {
+		// Damaged.
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a := t.aircraft[m.ICAO]
+	if a == nil {
+		// Only believe in a new aircraft if we can check the CRC, otherwise
+		// every damaged Address/Parity message would create a phantom.
+		if !verified {
+			return
+		}
+		a = &state{
+			Aircraft: Aircraft{ICAO: m.ICAO},
+			remotes:  make(map[string]time.Time),
+		}
+		t.aircraft[m.ICAO] = a
+	}
+
+	t.messages++
+	a.LastSeen = now
+	a.Messages++
+	a.remotes[remote] = now
+	if f.Signal != 0 {
+		a.signals[a.nSignals%rssiSamples] = f.RSSI()
+		a.nSignals++
+	}
+
+	a.apply(m, now, t.reference)
+}
+
+func (a *state) apply(m modes.Message, now time.Time, ref *location) {
+	if m.Callsign != "" {
+		a.Callsign = m.Callsign
+	}
+	if m.Category != "" {
+		a.Category = m.Category
+	}
+	if m.Squawk != "" {
+		a.Squawk = m.Squawk
+	}
+	if m.HasAltitude {
+		a.HasAltitude, a.Altitude = true, m.Altitude
+	}
+	if m.HasOnGround {
+		a.HasOnGround, a.OnGround = true, m.OnGround
+	}
+	if m.HasVelocity {
+		a.HasVelocity, a.GroundSpeed, a.Track = true, m.GroundSpeed, m.Track
+	}
+	if m.HasVerticalRate {
+		a.HasVerticalRate, a.VerticalRate = true, m.VerticalRate
+	}
+	if m.HasPosition {
+		a.updatePosition(m.Position, now, ref)
+	}
+}
+
+// updatePosition decodes a new position report. Surface reports can only be
+// decoded in pairs if the receivers' location, ref, is known.
+func (a *state) updatePosition(cpr modes.CPR, now time.Time, ref *location) {
+	r := report{cpr: cpr, seen: now}
+	other := a.even
+	if cpr.Odd {
+		a.odd = r
+	} else {
+		a.even = r
+		other = a.odd
+	}
+
+	if cpr.Surface == other.cpr.Surface && !other.seen.IsZero() && now.Sub(other.seen) <= pairInterval {
+		even, odd := a.even.cpr, a.odd.cpr
+		var (
+			lat, lon float64
+			ok       bool
+		)
+		switch {
+		case !cpr.Surface:
+			lat, lon, ok = modes.DecodeGlobal(even, odd, cpr.Odd)
+		case ref != nil:
+			lat, lon, ok = modes.DecodeGlobalSurface(even, odd, cpr.Odd, ref.lat, ref.lon)
+		}
+		if ok {
+			a.setPosition(lat, lon, now)
+			return
+		}
+	}
+
+	if a.HasPosition && now.Sub(a.PositionTime) <= referenceAge {
+		lat, lon := modes.DecodeLocal(cpr, a.Lat, a.Lon)
+		a.setPosition(lat, lon, now)
+	}
+}
+
+func (a *state) setPosition(lat, lon float64, now time.Time) {
+	a.HasPosition = true
+	a.Lat, a.Lon, a.PositionTime = lat, lon, now
+}
+
+// snapshot returns a copy of the aircraft, safe to use without the lock.
+func (a *state) snapshot() Aircraft {
+	c := a.Aircraft
+
+	c.Remotes = make([]string, 0, len(a.remotes))
+	for r := range a.remotes {
+		c.Remotes = append(c.Remotes, r)
+	}
+	sort.Strings(c.Remotes)
+
+	c.RSSI = math.Inf(-1)
+	if n := a.nSignals; n > 0 {
+		if n > rssiSamples {
+			n = rssiSamples
+		}
+		sum := 0.0
+		for _, s := range a.signals[:n] {
+			sum += s
+		}
+		c.RSSI = sum / float64(n)
+	}
+
+	return c
+}
+
+// Get returns the current state of an aircraft.
+func (t *Tracker) Get(icao uint32) (Aircraft, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	a := t.aircraft[icao]
+	if a == nil {
+		return Aircraft{}, false
+	}
+
+	return a.snapshot(), true
+}
+
+// All returns the current state of every aircraft, ordered by ICAO address.
+func (t *Tracker) All() []Aircraft {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	all := make([]Aircraft, 0, len(t.aircraft))
+	for _, a := range t.aircraft {
+		all = append(all, a.snapshot())
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ICAO < all[j].ICAO })
+
+	return all
+}
+
+// Len returns the number of aircraft being tracked.
+func (t *Tracker) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.aircraft)
+}
+
+// Messages returns the total number of messages attributed to aircraft.
+func (t *Tracker) Messages() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.messages
+}
+
+// Position returns the last decoded position of the aircraft. ok is false if
+// the aircraft is unknown, or its position has never been decoded. It is
+// cheaper than Get.
+func (t *Tracker) Position(icao uint32) (lat, lon float64, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	a := t.aircraft[icao]
+	if a == nil || !a.HasPosition {
+		return 0, 0, false
+	}
+
+	return a.Lat, a.Lon, true
+}
+
+// Expire forgets aircraft that have not been heard from since the expiry
+// period before now, and the remotes that have not heard them in that time.
+// It returns the addresses of the aircraft forgotten.
+func (t *Tracker) Expire(now time.Time) []uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []uint32
+	for icao, a := range t.aircraft {
+		if now.Sub(a.LastSeen) > t.expiry {
+			delete(t.aircraft, icao)
+			expired = append(expired, icao)
+			continue
+		}
+
+		for r, seen := range a.remotes {
+			if now.Sub(seen) > t.expiry {
+				delete(a.remotes, r)
+			}
+		}
+	}
+
+	return expired
+}
@@ -0,0 +1,154 @@
+package aircraft
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	evenPosition = "8d40621d58c382d690c8ac2863a7"
+	oddPosition  = "8d40621d58c386435cc412692ad6"
+)
+
+func TestPosition(t *testing.T) {
+	tr := NewTracker(time.Minute)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tr.Update("r1", frame(t, oddPosition), now)
+	_, _, ok := tr.Position(0x40621d)
+	assert.False(t, ok, "a single report can't be decoded")
+
+	tr.Update("r1", frame(t, evenPosition), now.Add(time.Second))
+	lat, lon, ok := tr.Position(0x40621d)
+	assert.True(t, ok)
+	assert.InDelta(t, 52.25720, lat, 0.00001)
+	assert.InDelta(t, 3.91937, lon, 0.00001)
+}
+
+func TestState(t *testing.T) {
+	tr := NewTracker(time.Minute)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	id := frame(t, "8d40621d202cc371c32ce0576098")
+	id.Data[1], id.Data[2], id.Data[3] = 0x40, 0x62, 0x1d
+	fixParity(id.Data)
+	id.Signal = 255
+
+	tr.Update("r1", id, now)
+	tr.Update("r2", frame(t, oddPosition), now)
+	tr.Update("r1", frame(t, evenPosition), now.Add(time.Second))
+
+	a, ok := tr.Get(0x40621d)
+	assert.True(t, ok)
+	assert.Equal(t, "KLM1023", a.Callsign)
+	assert.True(t, a.HasAltitude)
+	assert.Equal(t, 38000, a.Altitude)
+	assert.True(t, a.HasPosition)
+	assert.Equal(t, 3, a.Messages)
+	assert.Equal(t, []string{"r1", "r2"}, a.Remotes)
+	assert.Equal(t, 0.0, a.RSSI)
+	assert.Equal(t, now.Add(time.Second), a.LastSeen)
+
+	assert.Equal(t, 1, tr.Len())
+	assert.Equal(t, []Aircraft{a}, tr.All())
+
+	tr.Expire(now.Add(61 * time.Second))
+	a, _ = tr.Get(0x40621d)
+	assert.Equal(t, []string{"r1"}, a.Remotes)
+}
+
+func TestPairTooFarApart(t *testing.T) {
+	tr := NewTracker(time.Minute)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tr.Update("r1", frame(t, oddPosition), now)
+	tr.Update("r1", frame(t, evenPosition), now.Add(time.Minute))
+	_, _, ok := tr.Position(0x40621d)
+	assert.False(t, ok)
+}
+
+func TestDamagedMessageIgnored(t *testing.T) {
+	tr := NewTracker(time.Minute)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tr.Update("r1", frame(t, "8d40621d58c382d690c8ac2863a8"), now)
+	assert.Empty(t, tr.aircraft)
+}
+
+func TestExpire(t *testing.T) {
+	tr := NewTracker(time.Minute)
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tr.Update("r1", frame(t, oddPosition), now)
+	tr.Update("r1", frame(t, evenPosition), now)
+
+	tr.Expire(now.Add(30 * time.Second))
+	_, _, ok := tr.Position(0x40621d)
+	assert.True(t, ok)
+
+	assert.Equal(t, []uint32{0x40621d}, tr.Expire(now.Add(2*time.Minute)))
+	_, _, ok = tr.Position(0x40621d)
+	assert.False(t, ok)
+}
+
+func frame(t *testing.T, s string) beast.Frame {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return beast.Frame{Type: beast.ModeSLong, Data: b}
+}
+
+func fixParity(b []byte) {
+	p := modes.CRC(b[:len(b)-3])
+	b[len(b)-3], b[len(b)-2], b[len(b)-1] = byte(p>>16), byte(p>>8), byte(p)
+}
+
+func TestSurfacePosition(t *testing.T) {
+	const icao = 0x484175
+	// On the ground at Schiphol.
+	surface := func(odd bool) beast.Frame {
+		data := modes.EncodeSurfacePosition(icao, modes.EncodeSurfaceCPR(52.3105, 4.7683, odd))
+		return beast.Frame{Type: beast.ModeSLong, Data: data}
+	}
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Without the receivers' location, a pair of surface reports could be
+	// in any of four places.
+	tr := NewTracker(time.Minute)
+	tr.Update("r1", surface(false), now)
+	tr.Update("r1", surface(true), now.Add(time.Second))
+	_, _, ok := tr.Position(icao)
+	assert.False(t, ok)
+
+	// With it, they can be decoded.
+	tr = NewTracker(time.Minute)
+	tr.SetReference(51.99, 4.375)
+	tr.Update("r1", surface(false), now)
+	_, _, ok = tr.Position(icao)
+	assert.False(t, ok, "a single report can't be decoded")
+	tr.Update("r1", surface(true), now.Add(time.Second))
+	lat, lon, ok := tr.Position(icao)
+	assert.True(t, ok)
+	assert.InDelta(t, 52.3105, lat, 0.0001)
+	assert.InDelta(t, 4.7683, lon, 0.0001)
+	a, _ := tr.Get(icao)
+	assert.True(t, a.OnGround)
+
+	// An aircraft seen in the air needs no reference once it lands.
+	tr = NewTracker(time.Minute)
+	for _, odd := range []bool{false, true} {
+		data := modes.EncodeAirbornePosition(icao, 1000, modes.EncodeCPR(52.35, 4.70, odd))
+		tr.Update("r1", beast.Frame{Type: beast.ModeSLong, Data: data}, now)
+	}
+	tr.Update("r1", surface(false), now.Add(time.Minute))
+	lat, lon, ok = tr.Position(icao)
+	assert.True(t, ok)
+	assert.InDelta(t, 52.3105, lat, 0.0001)
+	assert.InDelta(t, 4.7683, lon, 0.0001)
+}
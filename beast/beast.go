@@ -16,6 +16,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"os"
 )
 
@@ -23,11 +24,123 @@ const (
 	esc = 0x1a
 )
 
-//type Message struct {
-//	Timestamp   time.Time
-//	SignalLevel byte
-//	Data        []byte
-//}
+// Frame types, as found in the byte following the initial escape.
+const (
+	ModeAC     = '1'
+	ModeSShort = '2'
+	ModeSLong  = '3'
+	// ReceiverID frames are a readsb extension: 8 bytes identifying the
+	// receiver of the frame that follows, with no timestamp or signal level.
+	ReceiverID = 0xe3
+)
+
+// receiverIDLength is the length of the (unescaped) ReceiverID payload.
+const receiverIDLength = 8
+
+// Frame is the unescaped content of a single beast message.
+type Frame struct {
+	Type byte
+	// Timestamp is the 48-bit MLAT counter. Its meaning depends on the receiver;
+	// usually it counts at 12MHz.
+	Timestamp uint64
+	Signal    byte
+	Data      []byte
+}
+
+// ParseFrame removes the escaping from a message returned by ReadMessage and
+// splits it into its fields.
+func ParseFrame(msg []byte) (Frame, error) {
+	if len(msg) < 2 || msg[0] != esc || msg[1] == esc {
+		return Frame{}, InvalidMessage{Header: msg}
+	}
+
+	body := make([]byte, 0, len(msg)-2)
+	for i := 2; i < len(msg); i++ {
+		body = append(body, msg[i])
+		if msg[i] == esc {
+			// Skip the second byte of the escaped pair.
+			i++
+		}
+	}
+
+	if msg[1] == ReceiverID {
+		if len(body) != receiverIDLength {
+			return Frame{}, InvalidMessage{Header: msg}
+		}
+		return Frame{Type: ReceiverID, Data: body}, nil
+	}
+
+	if len(body) < 6+1 {
+		return Frame{}, InvalidMessage{Header: msg}
+	}
+
+	f := Frame{
+		Type:   msg[1],
+		Signal: body[6],
+		Data:   body[7:],
+	}
+	for _, b := range body[:6] {
+		f.Timestamp = f.Timestamp<<8 | uint64(b)
+	}
+
+	return f, nil
+}
+
+// Bytes returns the escaped wire representation of the frame.
+func (f Frame) Bytes() []byte {
+	buff := make([]byte, 0, 2+2*(6+1+len(f.Data)))
+	buff = append(buff, esc, f.Type)
+
+	appendEscaped := func(b byte) {
+		buff = append(buff, b)
+		if b == esc {
+			buff = append(buff, esc)
+		}
+	}
+
+	if f.Type == ReceiverID {
+		for _, b := range f.Data {
+			appendEscaped(b)
+		}
+		return buff
+	}
+
+	for shift := 40; shift >= 0; shift -= 8 {
+		appendEscaped(byte(f.Timestamp >> shift))
+	}
+	appendEscaped(f.Signal)
+	for _, b := range f.Data {
+		appendEscaped(b)
+	}
+
+	return buff
+}
+
+// NewReceiverIDFrame returns a frame identifying the receiver of the frames
+// that follow it.
+func NewReceiverIDFrame(id uint64) Frame {
+	data := make([]byte, receiverIDLength)
+	for i := range data {
+		data[i] = byte(id >> (56 - 8*i))
+	}
+	return Frame{Type: ReceiverID, Data: data}
+}
+
+// ReceiverID returns the identifier carried by a ReceiverID frame.
+func (f Frame) ReceiverID() uint64 {
+	var id uint64
+	for _, b := range f.Data {
+		id = id<<8 | uint64(b)
+	}
+	return id
+}
+
+// RSSI returns the signal level in dBFS. A signal level of zero (which some
+// receivers send when they do not measure it) gives -Inf.
+func (f Frame) RSSI() float64 {
+	s := float64(f.Signal) / 255
+	return 10 * math.Log10(s*s)
+}
 
 type InvalidMessage struct {
 	Header []byte
@@ -67,17 +180,19 @@ func ReadMessage(r *bufio.Reader) ([]byte, error) {
 	}
 
 	var (
-		mType         = buff[1]
-		payloadLength int
+		mType      = buff[1]
+		bodyLength int
 	)
 
 	switch mType {
 	case '1':
-		payloadLength = 2
+		bodyLength = 6 + 1 + 2
 	case '2':
-		payloadLength = 7
+		bodyLength = 6 + 1 + 7
 	case '3':
-		payloadLength = 14
+		bodyLength = 6 + 1 + 14
+	case ReceiverID:
+		bodyLength = receiverIDLength
 	default:
 		// TODO - use logging.
 		fmt.Fprintf(os.Stderr, "Unexpected mType: %d", mType)
@@ -86,7 +201,7 @@ func ReadMessage(r *bufio.Reader) ([]byte, error) {
 	}
 
 	pos := fixedSize
-	for i := 0; i < 6+1+payloadLength; i++ {
+	for i := 0; i < bodyLength; i++ {
 		b, err := r.ReadByte()
 		if err != nil {
 			return nil, err
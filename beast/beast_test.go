@@ -92,6 +92,50 @@ func TestFailsInLive(t *testing.T) {
 	assert.Equal(t, 5, len(msgs))
 }
 
+func TestParseFrame(t *testing.T) {
+	b, err := hex.DecodeString("1a33095545b41a1a697c8d406e69990cd82c1808026c12ab")
+	noError(t, err)
+
+	f, err := ParseFrame(b)
+	noError(t, err)
+	assert.Equal(t, byte(ModeSLong), f.Type)
+	assert.Equal(t, uint64(0x095545b41a69), f.Timestamp)
+	assert.Equal(t, byte(0x7c), f.Signal)
+	assert.Equal(t, 14, len(f.Data))
+	assert.Equal(t, "8d406e69990cd82c1808026c12ab", hex.EncodeToString(f.Data))
+
+	// Bytes must restore the escaping.
+	assert.Equal(t, b, f.Bytes())
+}
+
+func TestParseFrameInvalid(t *testing.T) {
+	_, err := ParseFrame(str("^^3111"))
+	assert.Error(t, err)
+
+	_, err = ParseFrame(str("^3111"))
+	assert.Error(t, err)
+}
+
+func TestReceiverID(t *testing.T) {
+	f := NewReceiverIDFrame(0x0102031a05060708)
+	b := f.Bytes()
+	assert.Equal(t, str("^\xe3\x01\x02\x03^^\x05\x06\x07\x08"), b)
+
+	msg, err := ReadMessage(bufio.NewReader(bytes.NewReader(append(b, str("^3111111112345678901234")...))))
+	noError(t, err)
+	assert.Equal(t, b, msg)
+
+	parsed, err := ParseFrame(msg)
+	noError(t, err)
+	assert.Equal(t, byte(ReceiverID), parsed.Type)
+	assert.Equal(t, uint64(0x0102031a05060708), parsed.ReceiverID())
+}
+
+func TestRSSI(t *testing.T) {
+	assert.Equal(t, 0.0, Frame{Signal: 255}.RSSI())
+	assert.InDelta(t, -6.02, Frame{Signal: 128}.RSSI(), 0.1)
+}
+
 func str(s string) []byte {
 	return []byte(strings.ReplaceAll(s, "^", "\x1a"))
 }
@@ -0,0 +1,100 @@
+// Package capture reads and writes recordings of beast streams.
+//
+// A capture file is text, one frame per line:
+//
+//	2022-03-04T10:11:12.123456789Z receiver.example.com:30005 1a33000003865d847f...
+//
+// giving the wall-clock time the frame arrived, the remote it came from and
+// the escaped beast message in hex, exactly as read from the wire. Blank
+// lines and lines starting with '#' are ignored.
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Record is a single captured beast message.
+type Record struct {
+	Time   time.Time
+	Remote string
+	// Message is the escaped beast message, as returned by beast.ReadMessage.
+	Message []byte
+}
+
+// AppendRecord appends the capture file representation of r, including the
+// trailing newline, to buff.
+func AppendRecord(buff []byte, r Record) []byte {
+	remote := r.Remote
+	if remote == "" {
+		remote = "-"
+	}
+
+	buff = r.Time.UTC().AppendFormat(buff, time.RFC3339Nano)
+	buff = append(buff, ' ')
+	buff = append(buff, remote...)
+	buff = append(buff, ' ')
+	buff = append(buff, hex.EncodeToString(r.Message)...)
+	return append(buff, '\n')
+}
+
+// ParseRecord parses a single line of a capture file, without its newline.
+func ParseRecord(line string) (Record, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return Record{}, fmt.Errorf("capture: expected 3 fields in %q", line)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return Record{}, fmt.Errorf("capture: %w", err)
+	}
+
+	msg, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return Record{}, fmt.Errorf("capture: %w", err)
+	}
+
+	remote := fields[1]
+	if remote == "-" {
+		remote = ""
+	}
+
+	return Record{Time: t, Remote: remote, Message: msg}, nil
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Read returns the next record, or io.EOF at the end of the file.
+func (r *Reader) Read() (Record, error) {
+	for r.s.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		rec, err := ParseRecord(line)
+		if err != nil {
+			return Record{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return rec, nil
+	}
+
+	if err := r.s.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
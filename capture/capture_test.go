@@ -0,0 +1,51 @@
+package capture
+
+import (
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	msg, _ := hex.DecodeString("1a330003865d847f58a8000bbdca3e51f0a800002a54da")
+	in := []Record{
+		{Time: time.Date(2022, 3, 4, 10, 11, 12, 123456789, time.UTC), Remote: "receiver:30005", Message: msg},
+		{Time: time.Date(2022, 3, 4, 10, 11, 13, 0, time.UTC), Message: msg},
+	}
+
+	var buff []byte
+	for _, r := range in {
+		buff = AppendRecord(buff, r)
+	}
+	assert.Equal(t,
+		"2022-03-04T10:11:12.123456789Z receiver:30005 1a330003865d847f58a8000bbdca3e51f0a800002a54da\n"+
+			"2022-03-04T10:11:13Z - 1a330003865d847f58a8000bbdca3e51f0a800002a54da\n",
+		string(buff))
+
+	r := NewReader(strings.NewReader("# A comment\n\n" + string(buff)))
+	for _, want := range in {
+		got, err := r.Read()
+		require.NoError(t, err)
+		assert.True(t, want.Time.Equal(got.Time))
+		assert.Equal(t, want.Remote, got.Remote)
+		assert.Equal(t, want.Message, got.Message)
+	}
+	_, err := r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, line := range []string{
+		"2022-03-04T10:11:12Z r1",
+		"yesterday r1 1a33",
+		"2022-03-04T10:11:12Z r1 1a3",
+	} {
+		_, err := NewReader(strings.NewReader(line)).Read()
+		assert.Error(t, err, line)
+	}
+}
@@ -0,0 +1,174 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	filePrefix = "capture-"
+	fileSuffix = ".capture"
+	// fileTime is the format of the time, of the first record, in file names.
+	// It sorts in time order.
+	fileTime = "20060102T150405.000Z"
+)
+
+// Options control where a Recorder writes and when it rotates and deletes
+// files. Zero values disable the corresponding limit.
+type Options struct {
+	Dir string
+	// MaxSize is the size in bytes at which a file is rotated.
+	MaxSize int64
+	// Interval rotates files on multiples of this duration, e.g. hourly.
+	Interval time.Duration
+	// MaxFiles is the number of files to keep, including the current one.
+	MaxFiles int
+	// MaxAge is how long to keep a file after it was last written.
+	MaxAge time.Duration
+}
+
+// Recorder writes records to a series of capture files in a directory. It is
+// not safe for concurrent use.
+type Recorder struct {
+	opts Options
+
+	f      *os.File
+	w      *bufio.Writer
+	name   string
+	size   int64
+	period time.Time
+	buff   []byte
+}
+
+func NewRecorder(opts Options) (*Recorder, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{opts: opts}, nil
+}
+
+// Write appends a record to the current file, first rotating it if it is too
+// big or the record starts a new interval.
+func (r *Recorder) Write(rec Record) error {
+	r.buff = AppendRecord(r.buff[:0], rec)
+
+	if r.f != nil && r.rotateBefore(rec.Time, len(r.buff)) {
+		if err := r.Close(); err != nil {
+			return err
+		}
+	}
+
+	if r.f == nil {
+		if err := r.open(rec.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.w.Write(r.buff)
+	r.size += int64(n)
+	return err
+}
+
+func (r *Recorder) rotateBefore(t time.Time, n int) bool {
+	if r.opts.MaxSize > 0 && r.size > 0 && r.size+int64(n) > r.opts.MaxSize {
+		return true
+	}
+
+	return r.opts.Interval > 0 && !t.Truncate(r.opts.Interval).Equal(r.period)
+}
+
+func (r *Recorder) open(t time.Time) error {
+	name := filepath.Join(r.opts.Dir, filePrefix+t.UTC().Format(fileTime)+fileSuffix)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f, r.w, r.name, r.size = f, bufio.NewWriter(f), name, info.Size()
+	if r.opts.Interval > 0 {
+		r.period = t.Truncate(r.opts.Interval)
+	}
+
+	return r.prune(t)
+}
+
+// Name returns the path of the file being written, or "" if none is open.
+func (r *Recorder) Name() string {
+	return r.name
+}
+
+// Flush writes buffered records to the current file.
+func (r *Recorder) Flush() error {
+	if r.w == nil {
+		return nil
+	}
+
+	return r.w.Flush()
+}
+
+// Close flushes and closes the current file. A subsequent Write opens a new
+// one.
+func (r *Recorder) Close() error {
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f, r.w, r.name, r.size = nil, nil, "", 0
+
+	return err
+}
+
+// prune deletes files beyond the retention limits, never including the
+// current file.
+func (r *Recorder) prune(now time.Time) error {
+	if r.opts.MaxFiles <= 0 && r.opts.MaxAge <= 0 {
+		return nil
+	}
+
+	names, err := filepath.Glob(filepath.Join(r.opts.Dir, filePrefix+"*"+fileSuffix))
+	if err != nil {
+		return err
+	}
+	// Newest first.
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	var firstErr error
+	kept := 0
+	for _, name := range names {
+		if name == r.name {
+			kept++
+			continue
+		}
+
+		remove := r.opts.MaxFiles > 0 && kept >= r.opts.MaxFiles
+		if !remove && r.opts.MaxAge > 0 {
+			info, err := os.Stat(name)
+			remove = err == nil && now.Sub(info.ModTime()) > r.opts.MaxAge
+		}
+
+		if !remove {
+			kept++
+			continue
+		}
+
+		if err := os.Remove(name); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("capture: pruning: %w", err)
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,106 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+
+func record(t time.Time) Record {
+	return Record{Time: t, Remote: "r1", Message: []byte{0x1a, 0x31, 0, 0, 0, 0, 0, 0, 0xff, 0x12, 0x34}}
+}
+
+func files(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	return names
+}
+
+func TestRotateInterval(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(Options{Dir: dir, Interval: time.Hour})
+	require.NoError(t, err)
+
+	require.NoError(t, r.Write(record(start.Add(10*time.Minute))))
+	require.NoError(t, r.Write(record(start.Add(50*time.Minute))))
+	require.NoError(t, r.Write(record(start.Add(70*time.Minute))))
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"capture-20220304T101000.000Z.capture",
+		"capture-20220304T111000.000Z.capture",
+	}, files(t, dir))
+
+	f, err := os.Open(filepath.Join(dir, "capture-20220304T101000.000Z.capture"))
+	require.NoError(t, err)
+	defer f.Close()
+	cr := NewReader(f)
+	for _, want := range []time.Duration{10 * time.Minute, 50 * time.Minute} {
+		rec, err := cr.Read()
+		require.NoError(t, err)
+		assert.True(t, start.Add(want).Equal(rec.Time))
+	}
+}
+
+func TestRotateSize(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(len(AppendRecord(nil, record(start))))
+	r, err := NewRecorder(Options{Dir: dir, MaxSize: 2 * size})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, r.Write(record(start.Add(time.Duration(i)*time.Second))))
+	}
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"capture-20220304T100000.000Z.capture",
+		"capture-20220304T100002.000Z.capture",
+		"capture-20220304T100004.000Z.capture",
+	}, files(t, dir))
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(Options{Dir: dir, Interval: time.Minute, MaxFiles: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.Write(record(start.Add(time.Duration(i)*time.Minute))))
+	}
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"capture-20220304T100200.000Z.capture",
+		"capture-20220304T100300.000Z.capture",
+	}, files(t, dir))
+}
+
+func TestPruneAge(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "capture-20220101T000000.000Z.capture")
+	other := filepath.Join(dir, "unrelated.txt")
+	for _, name := range []string{old, other} {
+		require.NoError(t, os.WriteFile(name, nil, 0o644))
+		require.NoError(t, os.Chtimes(name, start.Add(-48*time.Hour), start.Add(-48*time.Hour)))
+	}
+
+	r, err := NewRecorder(Options{Dir: dir, MaxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, r.Write(record(start)))
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"capture-20220304T100000.000Z.capture",
+		"unrelated.txt",
+	}, files(t, dir))
+}
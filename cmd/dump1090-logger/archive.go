@@ -0,0 +1,190 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// archive compresses and deletes the files writers have finished with.
+type archive struct {
+	// compress is whether to gzip files once they have been rotated.
+	compress bool
+	// maxAge, if not zero, is how long after they were last written files
+	// are deleted.
+	maxAge time.Duration
+	// maxSize, if not zero, is the total size the files of all writers are
+	// kept within, by deleting the oldest.
+	maxSize int64
+
+	// jobs are the files waiting to be compressed.
+	jobs chan string
+}
+
+func newArchive(compress bool, maxAge time.Duration, maxSize int64) *archive {
+	a := &archive{
+		compress: compress,
+		maxAge:   maxAge,
+		maxSize:  maxSize,
+		jobs:     make(chan string, 100),
+	}
+	if compress {
+		go a.compressor()
+	}
+	return a
+}
+
+type archivedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// sweep queues the writers' finished files for compression and deletes those
+// that are too old or over the size limit. It must be called from the
+// goroutine using the writers.
+//
+// Files are only deleted to keep within maxSize once they have been
+// compressed (if compressing), so that a file isn't deleted while it is being
+// compressed. Files past maxAge are deleted rather than compressed.
+func (a *archive) sweep(now time.Time, writers []Writer) {
+	current := make(map[string]bool)
+	found := make(map[string]bool)
+	var files []archivedFile
+	for _, w := range writers {
+		fo := w.Options()
+		current[fo.current] = true
+
+		names, err := fo.existing()
+		if err != nil {
+			level.Warn(logger).Log("action", "archiving", "err", err)
+			continue
+		}
+		for _, name := range names {
+			if found[name] {
+				continue
+			}
+			found[name] = true
+
+			info, err := os.Stat(name)
+			if err != nil {
+				continue
+			}
+			files = append(files, archivedFile{name: name, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+
+	// Oldest first.
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	for _, f := range files {
+		if current[f.name] {
+			continue
+		}
+
+		expired := a.maxAge > 0 && now.Sub(f.modTime) > a.maxAge
+		if !expired && a.compress && !strings.HasSuffix(f.name, ".gz") {
+			select {
+			case a.jobs <- f.name:
+			default:
+				// Busy; try again at the next sweep.
+			}
+			continue
+		}
+
+		reason := ""
+		switch {
+		case expired:
+			reason = "age"
+		case a.maxSize > 0 && total > a.maxSize:
+			reason = "size"
+		default:
+			continue
+		}
+
+		if err := os.Remove(f.name); err != nil {
+			level.Warn(logger).Log("action", "pruning", "file", f.name, "err", err)
+			continue
+		}
+		level.Info(logger).Log("action", "pruned", "file", f.name, "reason", reason)
+		filesPruned.WithLabelValues(reason).Inc()
+		total -= f.size
+	}
+}
+
+func (a *archive) compressor() {
+	for name := range a.jobs {
+		if _, err := os.Stat(name); err != nil {
+			// Already compressed, in answer to an earlier sweep.
+			continue
+		}
+
+		if err := compressFile(name); err != nil {
+			level.Warn(logger).Log("action", "compressing", "file", name, "err", err)
+			continue
+		}
+		level.Info(logger).Log("action", "compressed", "file", name)
+		filesCompressed.Inc()
+	}
+}
+
+// compressFile replaces name by name.gz, with the same modification time.
+func compressFile(name string) (err error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := name + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(name)
+	gz.ModTime = info.ModTime()
+	if _, err = io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
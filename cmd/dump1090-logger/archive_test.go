@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveTest is a directory of files written by a CSV writer.
+type archiveTest struct {
+	dir string
+	now time.Time
+	w   *FileWriter
+}
+
+func newArchiveTest(t *testing.T) *archiveTest {
+	logger = log.NewNopLogger()
+	dir := t.TempDir()
+	return &archiveTest{
+		dir: dir,
+		now: time.Now().Truncate(time.Second),
+		w:   &FileWriter{fileOptions: fileOptions{Dir: dir, Template: "log-{date}", Ext: ".csv"}},
+	}
+}
+
+// file creates a file of the given size, last written age ago, and returns
+// its name.
+func (at *archiveTest) file(t *testing.T, name string, size int, age time.Duration) string {
+	name = filepath.Join(at.dir, name)
+	writeFile(t, name, size)
+	mtime := at.now.Add(-age)
+	require.NoError(t, os.Chtimes(name, mtime, mtime))
+	return name
+}
+
+// queued returns the files waiting to be compressed.
+func queued(a *archive) []string {
+	var names []string
+	for {
+		select {
+		case name := <-a.jobs:
+			names = append(names, name)
+		default:
+			return names
+		}
+	}
+}
+
+func TestSweepAge(t *testing.T) {
+	at := newArchiveTest(t)
+	a := &archive{compress: true, maxAge: 24 * time.Hour, jobs: make(chan string, 10)}
+
+	oldest := at.file(t, "log-2024-02-27.csv.gz", 10, 72*time.Hour)
+	old := at.file(t, "log-2024-02-28.csv", 10, 48*time.Hour)
+	recent := at.file(t, "log-2024-02-29.csv", 10, time.Hour)
+	// The file being written is kept however old it is.
+	at.w.current = at.file(t, "log-2024-03-01.csv", 10, 96*time.Hour)
+
+	before := testutil.ToFloat64(filesPruned.WithLabelValues("age"))
+	a.sweep(at.now, []Writer{at.w})
+
+	assert.NoFileExists(t, oldest)
+	// Expired files are deleted rather than compressed.
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, at.w.current)
+	assert.Equal(t, []string{recent}, queued(a))
+	assert.Equal(t, 2.0, testutil.ToFloat64(filesPruned.WithLabelValues("age"))-before)
+}
+
+func TestSweepSize(t *testing.T) {
+	at := newArchiveTest(t)
+	a := &archive{maxSize: 25, jobs: make(chan string, 10)}
+
+	// The file being written is kept even though it is the oldest.
+	at.w.current = at.file(t, "log-2024-02-27.csv", 10, 4*time.Hour)
+	b := at.file(t, "log-2024-02-28.csv", 10, 3*time.Hour)
+	c := at.file(t, "log-2024-02-29.csv", 10, 2*time.Hour)
+	d := at.file(t, "log-2024-03-01.csv", 10, time.Hour)
+
+	before := testutil.ToFloat64(filesPruned.WithLabelValues("size"))
+	a.sweep(at.now, []Writer{at.w})
+
+	assert.FileExists(t, at.w.current)
+	assert.NoFileExists(t, b)
+	assert.NoFileExists(t, c)
+	assert.FileExists(t, d)
+	assert.Empty(t, queued(a))
+	assert.Equal(t, 2.0, testutil.ToFloat64(filesPruned.WithLabelValues("size"))-before)
+}
+
+func TestSweepSizeAfterCompression(t *testing.T) {
+	at := newArchiveTest(t)
+	a := &archive{compress: true, maxSize: 15, jobs: make(chan string, 10)}
+
+	// Files still to be compressed are kept until they have been, so the
+	// oldest compressed file goes instead.
+	a1 := at.file(t, "log-2024-02-27.csv", 10, 4*time.Hour)
+	b := at.file(t, "log-2024-02-28.csv.gz", 10, 3*time.Hour)
+	c := at.file(t, "log-2024-02-29.csv", 10, 2*time.Hour)
+	at.w.current = at.file(t, "log-2024-03-01.csv", 10, time.Hour)
+
+	a.sweep(at.now, []Writer{at.w})
+
+	assert.FileExists(t, a1)
+	assert.NoFileExists(t, b)
+	assert.FileExists(t, c)
+	assert.FileExists(t, at.w.current)
+	assert.Equal(t, []string{a1, c}, queued(a))
+
+	// Once compressed, the oldest can go, leaving room for the others.
+	require.NoError(t, compressFile(a1))
+	require.NoError(t, compressFile(c))
+	info, err := os.Stat(c + ".gz")
+	require.NoError(t, err)
+	a.maxSize = info.Size() + 10
+	a.sweep(at.now, []Writer{at.w})
+
+	assert.NoFileExists(t, a1+".gz")
+	assert.FileExists(t, c+".gz")
+	assert.FileExists(t, at.w.current)
+}
+
+func TestCompressFile(t *testing.T) {
+	at := newArchiveTest(t)
+	name := filepath.Join(at.dir, "log-2024-03-01.csv")
+	require.NoError(t, os.WriteFile(name, []byte("type,hex\n3,40621D\n"), 0600))
+	mtime := at.now.Add(-time.Hour)
+	require.NoError(t, os.Chtimes(name, mtime, mtime))
+
+	require.NoError(t, compressFile(name))
+
+	assert.NoFileExists(t, name)
+	assert.NoFileExists(t, name+".gz.tmp")
+	info, err := os.Stat(name + ".gz")
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(mtime), "modification time %v, want %v", info.ModTime(), mtime)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	f, err := os.Open(name + ".gz")
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "type,hex\n3,40621D\n", string(b))
+	assert.Equal(t, "log-2024-03-01.csv", gz.Name)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"dump1090-proxy/sbs"
+)
+
+// Record is a message as received by the logger.
+type Record struct {
+	sbs.Message
+	// Received is when the logger read the message.
+	Received time.Time
+	// Source is the address of the server that sent it.
+	Source string
+}
+
+const timestampFormat = "2006-01-02T15:04:05.000"
+
+// columns maps each CSV column name to the function extracting its value.
+// Missing values are empty.
+var columns = map[string]func(r Record) string{
+	"type":          func(r Record) string { return strconv.Itoa(int(r.Type)) },
+	"timestamp":     func(r Record) string { return timestamp(r.Timestamp) },
+	"received":      func(r Record) string { return timestamp(r.Received) },
+	"source":        func(r Record) string { return r.Source },
+	"hex":           func(r Record) string { return r.HexIdent },
+	"callsign":      func(r Record) string { return r.Callsign },
+	"lat":           func(r Record) string { return number(r.Latitude) },
+	"lon":           func(r Record) string { return number(r.Longitude) },
+	"alt":           func(r Record) string { return number(r.Altitude) },
+	"ground_speed":  func(r Record) string { return number(r.GroundSpeed) },
+	"track":         func(r Record) string { return number(r.Track) },
+	"vertical_rate": func(r Record) string { return number(r.VerticalRate) },
+	"squawk":        func(r Record) string { return r.Squark },
+	"alert":         func(r Record) string { return strconv.FormatBool(r.Alert) },
+	"emergency":     func(r Record) string { return strconv.FormatBool(r.Emergency) },
+	"ident":         func(r Record) string { return strconv.FormatBool(r.Ident) },
+	"on_ground":     func(r Record) string { return strconv.FormatBool(r.OnGound) },
+}
+
+// allColumns is the default column order.
+var allColumns = []string{
+	"type", "timestamp", "received", "source", "hex", "callsign",
+	"lat", "lon", "alt", "ground_speed", "track", "vertical_rate",
+	"squawk", "alert", "emergency", "ident", "on_ground",
+}
+
+// parseColumns parses a comma-separated list of column names.
+func parseColumns(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q (want some of %s)", name, strings.Join(allColumns, ","))
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+
+	return names, nil
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(timestampFormat)
+}
+
+func number(f float64) string {
+	if math.IsNaN(f) {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"dump1090-proxy/sbs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr string
+	}{
+		{s: "hex", want: []string{"hex"}},
+		{s: "timestamp,hex,lat,lon", want: []string{"timestamp", "hex", "lat", "lon"}},
+		{s: " hex , callsign ,", want: []string{"hex", "callsign"}},
+		{s: "lon,lat", want: []string{"lon", "lat"}},
+		{s: "hex,latitude", wantErr: `unknown column "latitude"`},
+		{s: "HEX", wantErr: `unknown column "HEX"`},
+		{s: "", wantErr: "no columns"},
+		{s: " , ", wantErr: "no columns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseColumns(tt.s)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAllColumns(t *testing.T) {
+	// Every column is in the default order exactly once.
+	assert.Len(t, allColumns, len(columns))
+	for _, name := range allColumns {
+		assert.Contains(t, columns, name)
+	}
+}
+
+func TestColumnValues(t *testing.T) {
+	nan := math.NaN()
+	r := Record{
+		Message: sbs.Message{
+			Type:         sbs.AirbornePosition,
+			Timestamp:    time.Date(2024, 3, 1, 12, 0, 0, 123e6, time.UTC),
+			HexIdent:     "40621D",
+			Latitude:     52.2572,
+			Longitude:    3.91937,
+			Altitude:     38000,
+			GroundSpeed:  nan,
+			Track:        nan,
+			VerticalRate: nan,
+			OnGound:      true,
+		},
+		Source: "localhost:30003",
+	}
+
+	want := map[string]string{
+		"type":          "3",
+		"timestamp":     "2024-03-01T12:00:00.123",
+		"received":      "",
+		"source":        "localhost:30003",
+		"hex":           "40621D",
+		"callsign":      "",
+		"lat":           "52.2572",
+		"lon":           "3.91937",
+		"alt":           "38000",
+		"ground_speed":  "",
+		"track":         "",
+		"vertical_rate": "",
+		"squawk":        "",
+		"alert":         "false",
+		"emergency":     "false",
+		"ident":         "false",
+		"on_ground":     "true",
+	}
+	for name, value := range want {
+		assert.Equal(t, value, columns[name](r), name)
+	}
+}
@@ -4,17 +4,32 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"dump1090-proxy/sbs"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	address                = kingpin.Flag("remote", "Dump1090 server to connect to (beast format)").Required().TCP()
+	address                = kingpin.Flag("remote", "Dump1090 server to connect to, serving the format given by --format").Required().TCP()
+	format                 = kingpin.Flag("format", "Format of the remote's output: sbs (BaseStation, e.g. dump1090 port 30003) or beast (e.g. port 30005, or a dump1090-proxy).").Default("sbs").Enum("beast", "sbs")
+	mergeMaxAge            = kingpin.Flag("merge.max-age", "Add an aircraft's callsign, squawk, speed, track and vertical rate, received in other messages up to this long before, to each position logged.").Default("60s").Duration()
+	writerSpecs            = kingpin.Flag("writer", "Output to write: "+strings.Join(writerKinds, ", ")+", optionally followed by ?option=value&... (may be repeated).").Default("csv").Strings()
+	outputDir              = kingpin.Flag("output.dir", "Directory to write files to, unless a writer's dir option says otherwise.").Default(".").String()
+	outputTemplate         = kingpin.Flag("output.template", "Path of each file within the output directory, without its extension. May use {name}, {receiver}, {date}, {year}, {month}, {day} and {hour}, and / to make subdirectories.").Default("{name}-{date}").String()
+	receiverName           = kingpin.Flag("receiver", "Name of the receiver, for {receiver} in file names. Defaults to the remote's host.").String()
+	rotateEvery            = kingpin.Flag("rotate.every", "Start new files every hour or day.").Default("day").Enum("hour", "day")
+	rotateTimezone         = kingpin.Flag("rotate.timezone", "Time zone of the hours or days at which new files are started, and of the times in file names, e.g. UTC, Local or Europe/London.").Default("UTC").String()
+	rotateMaxSize          = kingpin.Flag("rotate.max-size", "Also start a new file when the current one reaches this size, or 0 for no limit.").Default("0").Bytes()
+	archiveCompress        = kingpin.Flag("archive.compress", "Compress files once new ones have been started: none or gzip.").Default("none").Enum("none", "gzip")
+	archiveMaxAge          = kingpin.Flag("archive.max-age", "Delete files this long after they were last written, or 0 to keep them.").Default("0").Duration()
+	archiveMaxSize         = kingpin.Flag("archive.max-size", "Delete the oldest files when all files together exceed this size, or 0 for no limit.").Default("0").Bytes()
 	webListenAddress       = kingpin.Flag("web.listen-address", "Address on which to expose metrics.").Default(":9796").String()
 	metricsEndpoint        = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 	disableExporterMetrics = kingpin.Flag(
@@ -24,11 +39,7 @@ var (
 
 	logger log.Logger
 
-	writers = []Writer{
-		// We just want the flat file for now.
-		// &DbWriter{},
-		&FileWriter{},
-	}
+	writers []Writer
 )
 
 func main() {
@@ -39,13 +50,57 @@ func main() {
 
 	logger = log.NewLogfmtLogger(os.Stderr)
 
-	ch := make(chan sbs.Message, 32)
+	loc, err := time.LoadLocation(*rotateTimezone)
+	if err != nil {
+		kingpin.Fatalf("--rotate.timezone: %v", err)
+	}
+
+	rot := rotation{every: *rotateEvery, loc: loc}
+
+	for _, check := range []func(string) error{checkTemplate, rot.checkTemplate} {
+		if err := check(*outputTemplate); err != nil {
+			kingpin.Fatalf("--output.template: %v", err)
+		}
+	}
+	defaults := fileOptions{
+		Dir:      *outputDir,
+		Template: *outputTemplate,
+		Name:     "dump1090",
+		Receiver: *receiverName,
+		MaxSize:  int64(*rotateMaxSize),
+	}
+	if defaults.Receiver == "" {
+		defaults.Receiver = (*address).IP.String()
+	}
 
-	go writer(ch)
+	for _, spec := range *writerSpecs {
+		w, err := newWriter(spec, defaults)
+		if err != nil {
+			kingpin.Fatalf("--writer: %v", err)
+		}
+		if err := rot.checkTemplate(w.Options().Template); err != nil {
+			kingpin.Fatalf("--writer: %q: %v", spec, err)
+		}
+		writers = append(writers, w)
+	}
+
+	ch := make(chan Record, 32)
+
+	go metricServer()
+	go writer(ch, rot,
+		newArchive(*archiveCompress == "gzip", *archiveMaxAge, int64(*archiveMaxSize)))
 	consume(*address, ch)
 }
 
-func consume(addr *net.TCPAddr, ch chan sbs.Message) {
+func metricServer() {
+	http.Handle(*metricsEndpoint, promhttp.Handler())
+	err := http.ListenAndServe(*webListenAddress, nil)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func consume(addr *net.TCPAddr, ch chan Record) {
 	backoff := time.Duration(0)
 	lastErrorLog := time.Time{}
 
@@ -77,15 +132,23 @@ func consume(addr *net.TCPAddr, ch chan sbs.Message) {
 	}
 }
 
-func runConnection(conn *net.TCPConn, ch chan<- sbs.Message) {
+func runConnection(conn *net.TCPConn, ch chan<- Record) {
 	defer conn.Close()
 	defer level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "action", "disconnected")
 
 	conn.CloseWrite()
 	conn.SetKeepAlive(true)
 	conn.SetKeepAlivePeriod(time.Minute)
-	reader := sbs.NewReader(conn)
+	var reader interface {
+		Read() (sbs.Message, error)
+	}
+	if *format == "beast" {
+		reader = sbs.NewBeastReader(conn)
+	} else {
+		reader = sbs.NewReader(conn)
+	}
 
+	source := conn.RemoteAddr().String()
 	seenFirstMessage := false
 	for {
 		m, err := reader.Read()
@@ -101,16 +164,13 @@ func runConnection(conn *net.TCPConn, ch chan<- sbs.Message) {
 
 		seenFirstMessage = true
 
-		ch <- m
+		ch <- Record{Message: m, Received: time.Now().UTC(), Source: source}
 	}
 }
 
-var (
-	nextRotate time.Time
-)
-
-func writer(ch <-chan sbs.Message) {
+func writer(ch <-chan Record, rot rotation, arc *archive) {
 	count := 0
+	var nextRotate, last, lastSweep time.Time
 
 	defer func() {
 		for _, w := range writers {
@@ -121,14 +181,33 @@ func writer(ch <-chan sbs.Message) {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
+	merger := sbs.NewMerger(*mergeMaxAge)
+
 	for {
 		select {
-		case <-ticker.C:
+		case now := <-ticker.C:
+			rotated := false
 			for _, w := range writers {
 				w.Flush()
+
+				if *rotateMaxSize > 0 && !last.IsZero() && w.Size() >= int64(*rotateMaxSize) {
+					if err := w.Rotate(last); err != nil {
+						panic(err)
+					}
+					rotated = true
+				}
+			}
+
+			// Files also age while none are being rotated.
+			if !nextRotate.IsZero() && (rotated || now.Sub(lastSweep) >= time.Hour) {
+				arc.sweep(now, writers)
+				lastSweep = now
 			}
 
 		case m := <-ch:
+			// Only positions are logged, but other messages carry details
+			// worth logging with them.
+			m.Message = merger.Merge(m.Message, rot.messageTime(m))
 			if math.IsNaN(m.Latitude) || math.IsNaN(m.Longitude) {
 				continue
 			}
@@ -138,14 +217,18 @@ func writer(ch <-chan sbs.Message) {
 				fmt.Println(count, int(m.Type), m.Timestamp, m.HexIdent, m.Latitude, m.Longitude, m.Altitude)
 			}
 
-			if nextRotate.IsZero() || m.Timestamp.After(nextRotate) {
+			last = rot.messageTime(m)
+			if nextRotate.IsZero() || !last.Before(nextRotate) {
 				// Need to rotate files
 				for _, w := range writers {
-					if err := w.Rotate(m.Timestamp); err != nil {
+					if err := w.Rotate(last); err != nil {
 						panic(err)
 					}
 				}
-				nextRotate = m.Timestamp.Truncate(24 * time.Hour).Add(24 * time.Hour)
+				nextRotate = rot.next(last)
+
+				arc.sweep(time.Now(), writers)
+				lastSweep = time.Now()
 			}
 
 			for _, w := range writers {
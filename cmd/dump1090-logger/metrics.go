@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	bytesWritten = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bytes_written",
+			Help: "The total number of bytes written to log files, before compression",
+		},
+		[]string{"writer"},
+	)
+	filesCompressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "files_compressed",
+		Help: "The total number of rotated log files compressed",
+	})
+	filesPruned = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "files_pruned",
+			Help: "The total number of log files deleted for being too old, or to keep within the total size limit",
+		},
+		[]string{"reason"},
+	)
+)
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	c prometheus.Counter
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.c.Add(float64(n))
+	return n, err
+}
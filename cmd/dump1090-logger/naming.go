@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// placeholders are the fields that may appear in a file name template, and
+// their values for a file starting at t.
+var placeholders = map[string]func(fo fileOptions, t time.Time) string{
+	"name":     func(fo fileOptions, t time.Time) string { return fo.Name },
+	"receiver": func(fo fileOptions, t time.Time) string { return fo.Receiver },
+	"date":     func(fo fileOptions, t time.Time) string { return t.Format("2006-01-02") },
+	"year":     func(fo fileOptions, t time.Time) string { return t.Format("2006") },
+	"month":    func(fo fileOptions, t time.Time) string { return t.Format("01") },
+	"day":      func(fo fileOptions, t time.Time) string { return t.Format("02") },
+	"hour":     func(fo fileOptions, t time.Time) string { return t.Format("15") },
+}
+
+// placeholderGlobs match the values of the time placeholders, so that
+// existing files can be found without matching others in the directory.
+var placeholderGlobs = map[string]string{
+	"date":  "[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]",
+	"year":  "[0-9][0-9][0-9][0-9]",
+	"month": "[0-9][0-9]",
+	"day":   "[0-9][0-9]",
+	"hour":  "[0-9][0-9]",
+}
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+var globSpecial = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+
+// fileOptions say where a writer puts its files.
+type fileOptions struct {
+	// Dir is the directory to write to.
+	Dir string
+	// Template gives the path of each file within Dir, without its extension.
+	// Placeholders such as {date} are replaced by the values from placeholders.
+	Template string
+	// Name and Receiver are the values of {name} and {receiver}.
+	Name     string
+	Receiver string
+	// MaxSize, if not zero, is the size beyond which a file is not reused.
+	MaxSize int64
+	// Ext is the extension of the writer's files, e.g. ".csv".
+	Ext string
+
+	// current is the name of the file being written.
+	current string
+}
+
+// checkTemplate returns an error if a template uses unknown placeholders, or
+// would write outside the output directory.
+func checkTemplate(template string) error {
+	for _, p := range placeholderPattern.FindAllString(template, -1) {
+		if _, ok := placeholders[p[1:len(p)-1]]; !ok {
+			return fmt.Errorf("unknown placeholder %s in %q", p, template)
+		}
+	}
+
+	clean := filepath.Clean(template)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q must be relative to the output directory", template)
+	}
+
+	return nil
+}
+
+// fileName returns the name of the file starting at timestamp, without its
+// extension.
+func (fo fileOptions) fileName(timestamp time.Time) string {
+	name := placeholderPattern.ReplaceAllStringFunc(fo.Template, func(p string) string {
+		// Values must not add directories of their own.
+		return strings.ReplaceAll(placeholders[p[1:len(p)-1]](fo, timestamp), "/", "_")
+	})
+	return filepath.Join(fo.Dir, name)
+}
+
+// createFileName returns the name of the file to write from timestamp
+// onwards, having created its directory if necessary. If the template's file
+// has reached MaxSize, has been compressed, or is not empty and doesn't fit
+// (e.g. because it was written with other columns), it is followed by .1, .2
+// and so on until one can be written. fits may be nil if any file will do.
+func (fo *fileOptions) createFileName(timestamp time.Time, fits func(fileName string) bool) (string, error) {
+	base := fo.fileName(timestamp)
+	fileName := base + fo.Ext
+	for seq := 1; !fo.usable(fileName, fits); seq++ {
+		fileName = fmt.Sprintf("%s.%d%s", base, seq, fo.Ext)
+	}
+
+	fo.current = fileName
+	return fileName, os.MkdirAll(filepath.Dir(fileName), 0755)
+}
+
+// usable reports whether a file may be written to.
+func (fo *fileOptions) usable(fileName string, fits func(fileName string) bool) bool {
+	if _, err := os.Stat(fileName + ".gz"); err == nil {
+		return false
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		// It will be created.
+		return true
+	}
+	if fo.MaxSize > 0 && info.Size() >= fo.MaxSize {
+		return false
+	}
+	return info.Size() == 0 || fits == nil || fits(fileName)
+}
+
+// existing returns the names of the files written using these options,
+// compressed or not: those whose names fit the template with any times and
+// sequence numbers.
+func (fo fileOptions) existing() ([]string, error) {
+	// Only the output directory itself is taken literally.
+	base := placeholderPattern.ReplaceAllStringFunc(globSpecial.Replace(fo.Template), func(p string) string {
+		key := p[1 : len(p)-1]
+		if glob, ok := placeholderGlobs[key]; ok {
+			return glob
+		}
+		return globSpecial.Replace(strings.ReplaceAll(placeholders[key](fo, time.Time{}), "/", "_"))
+	})
+	base = filepath.Join(globSpecial.Replace(fo.Dir), base)
+
+	var names []string
+	for _, suffix := range []string{fo.Ext, fo.Ext + ".gz"} {
+		matches, err := filepath.Glob(base + suffix)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+
+		// Followed by .1, .2 and so on.
+		matches, err = filepath.Glob(base + ".[1-9]*" + suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if isSequenced(name, base, suffix) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// isSequenced reports whether a file matching base+".[1-9]*"+suffix is
+// followed by just a sequence number, and not other text.
+func isSequenced(name string, base string, suffix string) bool {
+	stem := strings.TrimSuffix(name, suffix)
+	dot := strings.LastIndex(stem, ".")
+	if ok, _ := filepath.Match(base, stem[:dot]); !ok {
+		return false
+	}
+	for _, c := range stem[dot+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Options returns the options, including the current file.
+func (fo *fileOptions) Options() fileOptions {
+	return *fo
+}
+
+// Size returns the size of the file being written, as far as it has been
+// flushed.
+func (fo *fileOptions) Size() int64 {
+	if fo.current == "" {
+		return 0
+	}
+	info, err := os.Stat(fo.current)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name string, size int) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.NoError(t, os.WriteFile(name, make([]byte, size), 0644))
+}
+
+func TestCreateFileName(t *testing.T) {
+	dir := t.TempDir()
+	fo := fileOptions{Dir: dir, Template: "{year}/{month}/{name}-{date}", Name: "log", MaxSize: 10, Ext: ".csv"}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	base := filepath.Join(dir, "2024", "03", "log-2024-03-01")
+
+	// The directories are created for the first file.
+	name, err := fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".csv", name)
+	assert.Equal(t, name, fo.current)
+	assert.DirExists(t, filepath.Dir(name))
+
+	// A file with room is reused, e.g. after a restart.
+	writeFile(t, base+".csv", 9)
+	name, err = fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".csv", name)
+
+	// Full files are followed by .1, .2 and so on.
+	writeFile(t, base+".csv", 10)
+	name, err = fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".1.csv", name)
+
+	writeFile(t, base+".1.csv", 10)
+	name, err = fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".2.csv", name)
+	assert.Equal(t, name, fo.current)
+}
+
+func TestCreateFileNameAfterCompression(t *testing.T) {
+	dir := t.TempDir()
+	fo := fileOptions{Dir: dir, Template: "{name}-{date}", Name: "log", Ext: ".csv"}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	base := filepath.Join(dir, "log-2024-03-01")
+
+	// Compressed files are never appended to, even without a size limit.
+	writeFile(t, base+".csv.gz", 1)
+	name, err := fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".1.csv", name)
+
+	writeFile(t, base+".1.csv.gz", 1)
+	name, err = fo.createFileName(at, nil)
+	require.NoError(t, err)
+	assert.Equal(t, base+".2.csv", name)
+}
+
+func TestExisting(t *testing.T) {
+	dir := t.TempDir()
+	fo := fileOptions{Dir: dir, Template: "{receiver}/{year}/{month}/{name}-{date}", Name: "log", Receiver: "r1", Ext: ".csv"}
+
+	want := []string{
+		filepath.Join(dir, "r1", "2024", "02", "log-2024-02-29.csv.gz"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.1.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.2.csv.gz"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.10.csv"),
+	}
+	others := []string{
+		// Another receiver, another name, and another writer.
+		filepath.Join(dir, "r2", "2024", "03", "log-2024-03-01.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "other-2024-03-01.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.jsonl"),
+		// Not where the template puts files.
+		filepath.Join(dir, "r1", "log-2024-03-01.csv"),
+		// Someone else's files, where the template has times.
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.backup.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-2024-03-01.1.old.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-latest.csv"),
+		filepath.Join(dir, "r1", "2024", "notes", "log-2024-03-01.csv"),
+		filepath.Join(dir, "r1", "2024", "03", "log-24-03-01.csv"),
+	}
+	for _, name := range append(append([]string(nil), want...), others...) {
+		writeFile(t, name, 1)
+	}
+
+	names, err := fo.existing()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, names)
+}
+
+func TestCheckTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{template: "{name}-{date}"},
+		{template: "{receiver}/{year}/{month}/{day}/{name}-{hour}"},
+		{template: "logs/./{date}"},
+		// Staying within the directory is fine, however it's written.
+		{template: "a/../{date}"},
+		{template: "..{date}"},
+		{template: "{name}-{time}", wantErr: "unknown placeholder {time}"},
+		{template: "{name}-{Date}", wantErr: "unknown placeholder {Date}"},
+		{template: "../{date}", wantErr: "must be relative to the output directory"},
+		{template: "a/../../{date}", wantErr: "must be relative to the output directory"},
+		{template: "..", wantErr: "must be relative to the output directory"},
+		{template: "/var/log/{date}", wantErr: "must be relative to the output directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			err := checkTemplate(tt.template)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileName(t *testing.T) {
+	fo := fileOptions{Dir: "/var/log/dump1090", Template: "{receiver}/{year}/{month}/{day}/{name}-{date}-{hour}", Name: "log", Receiver: "home"}
+	at := time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC)
+	assert.Equal(t, "/var/log/dump1090/home/2024/03/01/log-2024-03-01-07", fo.fileName(at))
+
+	// Values can't add directories of their own.
+	fo.Receiver, fo.Name = "../home", "a/b"
+	assert.Equal(t, "/var/log/dump1090/.._home/2024/03/01/a_b-2024-03-01-07", fo.fileName(at))
+}
+
+func TestExistingTimesOnly(t *testing.T) {
+	dir := t.TempDir()
+	fo := fileOptions{Dir: dir, Template: "{date}", Ext: ".csv"}
+
+	// With only times in the template, other files in the directory must
+	// still be left alone.
+	want := []string{
+		filepath.Join(dir, "2024-03-01.csv"),
+		filepath.Join(dir, "2024-03-01.1.csv.gz"),
+	}
+	for _, name := range []string{"important.csv", "2024-03-01-edited.csv", "2024-03.csv", "backup.1.csv.gz"} {
+		writeFile(t, filepath.Join(dir, name), 1)
+	}
+	for _, name := range want {
+		writeFile(t, name, 1)
+	}
+
+	names, err := fo.existing()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, names)
+
+	// Glob characters in names are taken literally.
+	fo = fileOptions{Dir: dir, Template: "{name}-{date}", Name: "*", Ext: ".csv"}
+	writeFile(t, filepath.Join(dir, "*-2024-03-01.csv"), 1)
+	names, err = fo.existing()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "*-2024-03-01.csv")}, names)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"dump1090-proxy/spec"
+)
+
+// writerKinds are the names accepted by --writer.
+var writerKinds = []string{"csv", "jsonl", "sqlite"}
+
+// newWriter creates a Writer from a --writer value of the form
+// "kind?key=value&key=value". Options not given are taken from defaults.
+func newWriter(arg string, defaults fileOptions) (Writer, error) {
+	opts, err := spec.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	kind := opts.Name
+
+	files := fileOptions{
+		Dir:      opts.String("dir", defaults.Dir),
+		Template: opts.String("template", defaults.Template),
+		Name:     opts.String("name", defaults.Name),
+		Receiver: defaults.Receiver,
+		MaxSize:  defaults.MaxSize,
+	}
+	if err := checkTemplate(files.Template); err != nil {
+		return nil, opts.OptionError("template", err)
+	}
+
+	var w Writer
+	switch kind {
+	case "csv":
+		cols, err := parseColumns(opts.String("columns", strings.Join(allColumns, ",")))
+		if err != nil {
+			return nil, opts.OptionError("columns", err)
+		}
+		files.Ext = ".csv"
+		w = &FileWriter{fileOptions: files, Columns: cols}
+	case "jsonl":
+		files.Ext = ".jsonl"
+		w = &JSONWriter{fileOptions: files}
+	case "sqlite":
+		files.Ext = ".db"
+		w = &DbWriter{fileOptions: files}
+	default:
+		return nil, fmt.Errorf("unknown writer %q (want one of %s)", kind, strings.Join(writerKinds, ", "))
+	}
+
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
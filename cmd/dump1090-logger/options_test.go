@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDefaults = fileOptions{Dir: "/var/log/dump1090", Template: "{name}-{date}", Name: "dump1090", Receiver: "home", MaxSize: 1000}
+
+func TestNewWriter(t *testing.T) {
+	w, err := newWriter("csv", testDefaults)
+	require.NoError(t, err)
+	require.IsType(t, &FileWriter{}, w)
+	assert.Equal(t, allColumns, w.(*FileWriter).Columns)
+	want := testDefaults
+	want.Ext = ".csv"
+	assert.Equal(t, want, w.Options())
+
+	w, err = newWriter("jsonl", testDefaults)
+	require.NoError(t, err)
+	require.IsType(t, &JSONWriter{}, w)
+	assert.Equal(t, ".jsonl", w.Options().Ext)
+
+	w, err = newWriter("sqlite", testDefaults)
+	require.NoError(t, err)
+	require.IsType(t, &DbWriter{}, w)
+	assert.Equal(t, ".db", w.Options().Ext)
+}
+
+func TestNewWriterOptions(t *testing.T) {
+	// Each writer's options override the defaults, for that writer only.
+	w, err := newWriter("csv?dir=/tmp/csv&template={receiver}/{date}&name=positions&columns=hex,lat,lon", testDefaults)
+	require.NoError(t, err)
+	assert.Equal(t, fileOptions{
+		Dir:      "/tmp/csv",
+		Template: "{receiver}/{date}",
+		Name:     "positions",
+		Receiver: "home",
+		MaxSize:  1000,
+		Ext:      ".csv",
+	}, w.Options())
+	assert.Equal(t, []string{"hex", "lat", "lon"}, w.(*FileWriter).Columns)
+
+	w, err = newWriter("jsonl?dir=/tmp/jsonl", testDefaults)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/jsonl", w.Options().Dir)
+	assert.Equal(t, testDefaults.Template, w.Options().Template)
+	assert.Equal(t, testDefaults.Name, w.Options().Name)
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	tests := []struct {
+		arg     string
+		wantErr string
+	}{
+		{arg: "parquet", wantErr: `unknown writer "parquet"`},
+		{arg: "", wantErr: `unknown writer ""`},
+		{arg: "csv?colums=hex", wantErr: "csv: unknown option(s) colums"},
+		// Only CSV files have columns.
+		{arg: "jsonl?columns=hex&size=1", wantErr: "jsonl: unknown option(s) columns, size"},
+		{arg: "csv?columns=hex,latitude", wantErr: `csv: option "columns": unknown column "latitude"`},
+		{arg: "csv?template={time}", wantErr: `csv: option "template": unknown placeholder {time}`},
+		{arg: "jsonl?template=../{date}", wantErr: `jsonl: option "template": "../{date}" must be relative to the output directory`},
+		{arg: "csv?%zz", wantErr: "invalid URL escape"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			_, err := newWriter(tt.arg, testDefaults)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
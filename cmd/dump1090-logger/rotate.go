@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// rotation says when to start new files.
+type rotation struct {
+	// every is "hour" or "day".
+	every string
+	// loc is the time zone whose hours or days are used.
+	loc *time.Location
+}
+
+// checkTemplate returns an error if files made from template in different
+// periods could have the same name, and so be appended to as if they were
+// the same file.
+func (r rotation) checkTemplate(template string) error {
+	has := make(map[string]bool)
+	for _, p := range placeholderPattern.FindAllString(template, -1) {
+		has[p[1:len(p)-1]] = true
+	}
+
+	if !has["date"] && !(has["year"] && has["month"] && has["day"]) {
+		return fmt.Errorf("%q must use {date}, or {year}, {month} and {day}, to start new files every %s", template, r.every)
+	}
+	if r.every == "hour" && !has["hour"] {
+		return fmt.Errorf("%q must use {hour} to start new files every hour", template)
+	}
+	return nil
+}
+
+// next returns the start of the period following the one containing t.
+// Periods are counted in wall-clock time, so days are 23 or 25 hours long
+// when daylight saving time starts or ends.
+func (r rotation) next(t time.Time) time.Time {
+	t = t.In(r.loc)
+	y, m, d := t.Date()
+	if r.every == "hour" {
+		return time.Date(y, m, d, t.Hour()+1, 0, 0, 0, r.loc)
+	}
+	return time.Date(y, m, d+1, 0, 0, 0, 0, r.loc)
+}
+
+// messageTime returns the time to file a record under. Messages whose time
+// is unknown (e.g. because the SBS timestamp could not be parsed) are filed
+// by when they were received.
+func (r rotation) messageTime(rec Record) time.Time {
+	t := rec.Timestamp
+	if t.IsZero() {
+		t = rec.Received
+	}
+	return t.In(r.loc)
+}
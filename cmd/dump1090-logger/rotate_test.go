@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"dump1090-proxy/sbs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, s string) time.Time {
+	ts, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return ts
+}
+
+func TestRotationNext(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		every string
+		loc   *time.Location
+		t     string
+		want  string
+	}{
+		{name: "hour", every: "hour", loc: time.UTC, t: "2024-03-01T12:34:56Z", want: "2024-03-01T13:00:00Z"},
+		{name: "day", every: "day", loc: time.UTC, t: "2024-03-01T12:34:56Z", want: "2024-03-02T00:00:00Z"},
+		{name: "end of month", every: "day", loc: time.UTC, t: "2024-02-29T23:59:59Z", want: "2024-03-01T00:00:00Z"},
+		{name: "local day", every: "day", loc: london, t: "2024-07-01T23:30:00Z", want: "2024-07-02T23:00:00Z"},
+
+		// Clocks go forward at 01:00 GMT on 31 March 2024, so that day is
+		// 23 hours long.
+		{name: "hour before spring", every: "hour", loc: london, t: "2024-03-31T00:30:00Z", want: "2024-03-31T01:00:00Z"},
+		{name: "hour after spring", every: "hour", loc: london, t: "2024-03-31T01:30:00Z", want: "2024-03-31T02:00:00Z"},
+		{name: "day of spring", every: "day", loc: london, t: "2024-03-31T12:00:00Z", want: "2024-03-31T23:00:00Z"},
+
+		// Clocks go back at 02:00 BST on 27 October 2024, so 01:00 to 02:00
+		// happens twice. Both belong to the same hour, which is two hours
+		// long, and the day is 25 hours long.
+		{name: "first repeated hour", every: "hour", loc: london, t: "2024-10-27T00:30:00Z", want: "2024-10-27T02:00:00Z"},
+		{name: "second repeated hour", every: "hour", loc: london, t: "2024-10-27T01:30:00Z", want: "2024-10-27T02:00:00Z"},
+		{name: "day of autumn", every: "day", loc: london, t: "2024-10-26T23:00:00Z", want: "2024-10-28T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rotation{every: tt.every, loc: tt.loc}
+			got := r.next(mustParse(t, tt.t))
+			assert.Equal(t, mustParse(t, tt.want).UTC(), got.UTC())
+			assert.Equal(t, tt.loc, got.Location())
+		})
+	}
+}
+
+func TestMessageTime(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	r := rotation{every: "day", loc: london}
+
+	sent, received := mustParse(t, "2024-07-01T23:30:00Z"), mustParse(t, "2024-07-01T23:30:01Z")
+
+	got := r.messageTime(Record{Message: sbs.Message{Timestamp: sent}, Received: received})
+	assert.True(t, got.Equal(sent))
+	assert.Equal(t, london, got.Location())
+	assert.Equal(t, "2024-07-02", got.Format("2006-01-02"))
+
+	// Without a timestamp, the message is filed by when it was received.
+	got = r.messageTime(Record{Received: received})
+	assert.True(t, got.Equal(received))
+	assert.Equal(t, london, got.Location())
+}
+
+func TestRotationCheckTemplate(t *testing.T) {
+	tests := []struct {
+		every    string
+		template string
+		wantErr  string
+	}{
+		{every: "day", template: "{name}-{date}"},
+		{every: "day", template: "{year}/{month}/{name}-{day}"},
+		{every: "day", template: "{name}-{date}-{hour}"},
+		{every: "hour", template: "{name}-{date}-{hour}"},
+		{every: "hour", template: "{receiver}/{year}/{month}/{day}/{name}-{hour}"},
+		{every: "hour", template: "{name}-{date}", wantErr: "must use {hour} to start new files every hour"},
+		{every: "hour", template: "{name}-{hour}", wantErr: "must use {date}, or {year}, {month} and {day}, to start new files every hour"},
+		{every: "day", template: "{name}", wantErr: "must use {date}, or {year}, {month} and {day}, to start new files every day"},
+		{every: "day", template: "{month}/{name}-{day}", wantErr: "must use {date}, or {year}, {month} and {day}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.every+" "+tt.template, func(t *testing.T) {
+			err := rotation{every: tt.every, loc: time.UTC}.checkTemplate(tt.template)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,66 +1,141 @@
 package main
 
 import (
+	"bufio"
 	"database/sql"
 	"encoding/csv"
-	"fmt"
+	"encoding/json"
+	"math"
 	"os"
+	"strings"
 	"time"
 
-	"dump1090-proxy/sbs"
 	"github.com/go-kit/log/level"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Writer interface {
-	Write(m sbs.Message) error
+	Write(r Record) error
+	// Rotate starts writing to the file for timestamp, which may be the
+	// current file if it has room.
 	Rotate(timestamp time.Time) error
 	Flush()
+	// Size is the size of the current file.
+	Size() int64
+	// Options says where the files are.
+	Options() fileOptions
 	Close() error
 }
 
+// DbWriter writes SQLite databases, with a messages table holding the same
+// fields as the CSV columns.
 type DbWriter struct {
+	fileOptions
+
 	db   *sql.DB
 	stmt *sql.Stmt
+	// size is the size of the database when last flushed.
+	size int64
 }
 
 func (db *DbWriter) Flush() {
-	// No action needed
+	// Nothing is buffered, but count how much has been written.
+	if size := db.Size(); size > db.size {
+		bytesWritten.WithLabelValues("sqlite").Add(float64(size - db.size))
+		db.size = size
+	}
 }
 
-func (db *DbWriter) Write(m sbs.Message) error {
-	_, err := db.stmt.Exec(int(m.Type), m.Timestamp, m.HexIdent, m.Latitude, m.Longitude, m.Altitude)
+func (db *DbWriter) Write(m Record) error {
+	_, err := db.stmt.Exec(int(m.Type), m.Timestamp, m.Received, m.Source, m.HexIdent, m.Callsign,
+		m.Latitude, m.Longitude, m.Altitude, m.GroundSpeed, m.Track, m.VerticalRate,
+		m.Squark, m.Alert, m.Emergency, m.Ident, m.OnGound)
 	return err
 }
 
+// dbColumns are the columns of the messages table, as created by Rotate.
+var dbColumns = []string{"type", "timestamp", "received", "source", "hexIdent", "callsign",
+	"lat", "lon", "alt", "groundSpeed", "track", "verticalRate",
+	"squawk", "alert", "emergency", "ident", "onGround"}
+
 func (db *DbWriter) Rotate(timestamp time.Time) error {
+	db.Flush()
 	_ = db.Close()
 
-	fileName := "./dump1090-" + timestamp.Format("2006-01-02") + ".db"
+	fileName, err := db.createFileName(timestamp, db.fits)
+	if err != nil {
+		return err
+	}
 	level.Info(logger).Log("rotating", fileName)
+	db.size = db.Size()
 
-	var err error
 	db.db, err = sql.Open("sqlite3", fileName)
 	if err != nil {
 		return err
 	}
 
-	// Ignore failures ("already exists")
-	_, _ = db.db.Exec(`
-			create table messages(type integer, timestamp text, hexIdent text, lat float64, lon float64, alt float64)
+	// The table already exists if the logger was restarted.
+	_, err = db.db.Exec(`
+			create table if not exists messages(type integer, timestamp text, received text, source text, hexIdent text, callsign text,
+				lat float64, lon float64, alt float64, groundSpeed float64, track float64, verticalRate float64,
+				squawk text, alert boolean, emergency boolean, ident boolean, onGround boolean)
 		`)
-
 	if err != nil {
 		return err
 	}
 
 	db.stmt, err = db.db.Prepare(`
-			insert into messages(type, timestamp , hexIdent, lat, lon, alt)
-			values(?, strftime('%Y-%m-%d %H:%M:%f', ?), ?, ?, ?, ?)`)
+			insert into messages(type, timestamp, received, source, hexIdent, callsign,
+				lat, lon, alt, groundSpeed, track, verticalRate,
+				squawk, alert, emergency, ident, onGround)
+			values(?, strftime('%Y-%m-%d %H:%M:%f', ?), strftime('%Y-%m-%d %H:%M:%f', ?), ?, ?, ?,
+				?, ?, ?, ?, ?, ?,
+				?, ?, ?, ?, ?)`)
 
 	return err
 }
 
+// fits reports whether an existing database has no messages table, or one
+// with the same columns, so can be added to.
+func (db *DbWriter) fits(fileName string) bool {
+	cols, err := tableColumns(fileName, "messages")
+	if err != nil {
+		level.Warn(logger).Log("action", "checking", "file", fileName, "err", err)
+		return false
+	}
+	if len(cols) > 0 && strings.Join(cols, ",") != strings.Join(dbColumns, ",") {
+		level.Info(logger).Log("action", "skipped", "file", fileName, "reason", "columns")
+		return false
+	}
+	return true
+}
+
+// tableColumns returns the names of a table's columns, or none if there is
+// no such table.
+func tableColumns(fileName string, table string) ([]string, error) {
+	d, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	rows, err := d.Query("select name from pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
 func (db *DbWriter) Close() error {
 	if db.stmt != nil {
 		db.stmt.Close()
@@ -72,44 +147,81 @@ func (db *DbWriter) Close() error {
 	return nil
 }
 
+// FileWriter writes CSV files, starting each with a header row.
 type FileWriter struct {
+	fileOptions
+	// Columns are the names of the columns to write, from allColumns.
+	Columns []string
+
 	file *os.File
 	c    *csv.Writer
+	row  []string
 }
 
 func (fw *FileWriter) Flush() {
-	fw.c.Flush()
+	if fw.c != nil {
+		fw.c.Flush()
+	}
 }
 
-func (fw *FileWriter) Write(m sbs.Message) error {
-	err := fw.c.Write([]string{
-		fmt.Sprint(int(m.Type)),
-		m.Timestamp.Format("2006-01-02T15:04:05.000"),
-		m.HexIdent,
-		fmt.Sprint(m.Latitude),
-		fmt.Sprint(m.Longitude),
-		fmt.Sprint(m.Altitude),
-	})
-	return err
+func (fw *FileWriter) Write(r Record) error {
+	fw.row = fw.row[:0]
+	for _, name := range fw.Columns {
+		fw.row = append(fw.row, columns[name](r))
+	}
+	return fw.c.Write(fw.row)
 }
 
 func (fw *FileWriter) Rotate(timestamp time.Time) error {
 	_ = fw.Close()
 
-	fileName := "./dump1090-" + timestamp.Format("2006-01-02") + ".csv"
+	fileName, err := fw.createFileName(timestamp, fw.fits)
+	if err != nil {
+		return err
+	}
 	level.Info(logger).Log("rotating", fileName)
 
-	var err error
 	fw.file, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 
-	fw.c = csv.NewWriter(fw.file)
+	fw.c = csv.NewWriter(countingWriter{fw.file, bytesWritten.WithLabelValues("csv")})
+
+	// A file may already exist, with its header, if the logger was restarted.
+	info, err := fw.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fw.c.Write(fw.Columns)
+	}
 
 	return nil
 }
 
+// fits reports whether an existing file has the same header, so can be
+// appended to.
+func (fw *FileWriter) fits(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		level.Warn(logger).Log("action", "checking", "file", fileName, "err", err)
+		return false
+	}
+	defer f.Close()
+
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		level.Warn(logger).Log("action", "checking", "file", fileName, "err", err)
+		return false
+	}
+	if strings.Join(header, ",") != strings.Join(fw.Columns, ",") {
+		level.Info(logger).Log("action", "skipped", "file", fileName, "reason", "columns")
+		return false
+	}
+	return true
+}
+
 func (fw *FileWriter) Close() error {
 	if fw.c != nil {
 		fw.c.Flush()
@@ -122,7 +234,112 @@ func (fw *FileWriter) Close() error {
 	return nil
 }
 
+// JSONWriter writes JSON Lines files: one object per message, with the same
+// names as the CSV columns. Missing values are omitted.
+type JSONWriter struct {
+	fileOptions
+
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+type jsonRecord struct {
+	Type         int        `json:"type"`
+	Timestamp    *time.Time `json:"timestamp,omitempty"`
+	Received     *time.Time `json:"received,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	HexIdent     string     `json:"hex"`
+	Callsign     string     `json:"callsign,omitempty"`
+	Latitude     *float64   `json:"lat,omitempty"`
+	Longitude    *float64   `json:"lon,omitempty"`
+	Altitude     *float64   `json:"alt,omitempty"`
+	GroundSpeed  *float64   `json:"ground_speed,omitempty"`
+	Track        *float64   `json:"track,omitempty"`
+	VerticalRate *float64   `json:"vertical_rate,omitempty"`
+	Squawk       string     `json:"squawk,omitempty"`
+	Alert        bool       `json:"alert"`
+	Emergency    bool       `json:"emergency"`
+	Ident        bool       `json:"ident"`
+	OnGround     bool       `json:"on_ground"`
+}
+
+func (jw *JSONWriter) Flush() {
+	if jw.w != nil {
+		jw.w.Flush()
+	}
+}
+
+func (jw *JSONWriter) Write(r Record) error {
+	return jw.enc.Encode(jsonRecord{
+		Type:         int(r.Type),
+		Timestamp:    optionalTime(r.Timestamp),
+		Received:     optionalTime(r.Received),
+		Source:       r.Source,
+		HexIdent:     r.HexIdent,
+		Callsign:     r.Callsign,
+		Latitude:     optionalNumber(r.Latitude),
+		Longitude:    optionalNumber(r.Longitude),
+		Altitude:     optionalNumber(r.Altitude),
+		GroundSpeed:  optionalNumber(r.GroundSpeed),
+		Track:        optionalNumber(r.Track),
+		VerticalRate: optionalNumber(r.VerticalRate),
+		Squawk:       r.Squark,
+		Alert:        r.Alert,
+		Emergency:    r.Emergency,
+		Ident:        r.Ident,
+		OnGround:     r.OnGound,
+	})
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func optionalNumber(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return &f
+}
+
+func (jw *JSONWriter) Rotate(timestamp time.Time) error {
+	_ = jw.Close()
+
+	fileName, err := jw.createFileName(timestamp, nil)
+	if err != nil {
+		return err
+	}
+	level.Info(logger).Log("rotating", fileName)
+
+	jw.file, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	jw.w = bufio.NewWriter(countingWriter{jw.file, bytesWritten.WithLabelValues("jsonl")})
+	jw.enc = json.NewEncoder(jw.w)
+
+	return nil
+}
+
+func (jw *JSONWriter) Close() error {
+	if jw.w != nil {
+		jw.w.Flush()
+	}
+
+	if jw.file != nil {
+		return jw.file.Close()
+	}
+
+	return nil
+}
+
 var (
 	_ Writer = &DbWriter{}
 	_ Writer = &FileWriter{}
+	_ Writer = &JSONWriter{}
 )
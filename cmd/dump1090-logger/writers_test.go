@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dump1090-proxy/sbs"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readJSONL returns the objects in a JSON Lines file.
+func readJSONL(t *testing.T, name string) []map[string]interface{} {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var l []map[string]interface{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(s.Bytes(), &m), s.Text())
+		l = append(l, m)
+	}
+	require.NoError(t, s.Err())
+	return l
+}
+
+func TestJSONWriter(t *testing.T) {
+	logger = log.NewNopLogger()
+	nan := math.NaN()
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	jw := &JSONWriter{fileOptions: fileOptions{Dir: t.TempDir(), Template: "{name}-{date}", Name: "log", Ext: ".jsonl"}}
+	require.NoError(t, jw.Rotate(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+
+	require.NoError(t, jw.Write(Record{
+		Message: sbs.Message{
+			Type:         sbs.AirbornePosition,
+			Timestamp:    time.Date(2024, 7, 1, 12, 0, 0, 123e6, london),
+			HexIdent:     "40621D",
+			Callsign:     "EZY12A",
+			Latitude:     52.2572,
+			Longitude:    3.91937,
+			Altitude:     38000,
+			GroundSpeed:  450,
+			Track:        90,
+			VerticalRate: -640,
+			Squark:       "7700",
+			Emergency:    true,
+		},
+		Received: time.Date(2024, 7, 1, 11, 0, 1, 0, time.UTC),
+		Source:   "localhost:30003",
+	}))
+	// Only the type, hex and flags are always present.
+	require.NoError(t, jw.Write(Record{
+		Message: sbs.Message{
+			Type:         sbs.AllCallReply,
+			HexIdent:     "400001",
+			Altitude:     nan,
+			GroundSpeed:  nan,
+			Track:        nan,
+			Latitude:     nan,
+			Longitude:    nan,
+			VerticalRate: nan,
+		},
+	}))
+	require.NoError(t, jw.Close())
+
+	l := readJSONL(t, jw.current)
+	require.Len(t, l, 2)
+	assert.Equal(t, map[string]interface{}{
+		"type":          float64(sbs.AirbornePosition),
+		"timestamp":     "2024-07-01T12:00:00.123+01:00",
+		"received":      "2024-07-01T11:00:01Z",
+		"source":        "localhost:30003",
+		"hex":           "40621D",
+		"callsign":      "EZY12A",
+		"lat":           52.2572,
+		"lon":           3.91937,
+		"alt":           38000.0,
+		"ground_speed":  450.0,
+		"track":         90.0,
+		"vertical_rate": -640.0,
+		"squawk":        "7700",
+		"alert":         false,
+		"emergency":     true,
+		"ident":         false,
+		"on_ground":     false,
+	}, l[0])
+	assert.Equal(t, map[string]interface{}{
+		"type":      float64(sbs.AllCallReply),
+		"hex":       "400001",
+		"alert":     false,
+		"emergency": false,
+		"ident":     false,
+		"on_ground": false,
+	}, l[1])
+
+	// Times are RFC3339, and the names are those of the CSV columns.
+	for _, key := range []string{"timestamp", "received"} {
+		_, err := time.Parse(time.RFC3339Nano, l[0][key].(string))
+		assert.NoError(t, err, key)
+	}
+	for key := range l[0] {
+		assert.Contains(t, columns, key)
+	}
+}
+
+func TestFileWriterColumnsChanged(t *testing.T) {
+	logger = log.NewNopLogger()
+	dir := t.TempDir()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	csvWriter := func(cols ...string) *FileWriter {
+		return &FileWriter{fileOptions: fileOptions{Dir: dir, Template: "{date}", Ext: ".csv"}, Columns: cols}
+	}
+	write := func(fw *FileWriter) string {
+		require.NoError(t, fw.Rotate(at))
+		require.NoError(t, fw.Write(Record{Message: sbs.Message{HexIdent: "40621D", Callsign: "EZY12A"}}))
+		require.NoError(t, fw.Close())
+		return fw.current
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	first := write(csvWriter("hex"))
+	assert.Equal(t, filepath.Join(dir, "2024-03-01.csv"), first)
+
+	// Restarted with the same columns, the file is appended to.
+	assert.Equal(t, first, write(csvWriter("hex")))
+	assert.Equal(t, "hex\n40621D\n40621D\n", read(first))
+
+	// With other columns, another file is started rather than mixing them.
+	second := write(csvWriter("hex", "callsign"))
+	assert.Equal(t, filepath.Join(dir, "2024-03-01.1.csv"), second)
+	assert.Equal(t, "hex,callsign\n40621D,EZY12A\n", read(second))
+	assert.Equal(t, "hex\n40621D\n40621D\n", read(first))
+
+	// Going back to the first columns appends to the first file again.
+	assert.Equal(t, first, write(csvWriter("hex")))
+
+	// A file with something other than a header is never appended to.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2024-03-02.csv"), []byte("\"unterminated\n"), 0600))
+	at = at.AddDate(0, 0, 1)
+	assert.Equal(t, filepath.Join(dir, "2024-03-02.1.csv"), write(csvWriter("hex")))
+}
+
+func TestDbWriterColumnsChanged(t *testing.T) {
+	logger = log.NewNopLogger()
+	dir := t.TempDir()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	write := func() string {
+		db := &DbWriter{fileOptions: fileOptions{Dir: dir, Template: "{date}", Ext: ".db"}}
+		require.NoError(t, db.Rotate(at))
+		require.NoError(t, db.Write(Record{Message: sbs.Message{HexIdent: "40621D"}}))
+		require.NoError(t, db.Close())
+		return db.current
+	}
+	count := func(name string) int {
+		d, err := sql.Open("sqlite3", name)
+		require.NoError(t, err)
+		defer d.Close()
+		var n int
+		require.NoError(t, d.QueryRow("select count(*) from messages").Scan(&n))
+		return n
+	}
+
+	// Restarted, the same database is added to.
+	first := write()
+	assert.Equal(t, first, write())
+	assert.Equal(t, 2, count(first))
+
+	// A database from a version with other columns is left alone.
+	at = at.AddDate(0, 0, 1)
+	old := filepath.Join(dir, "2024-03-02.db")
+	d, err := sql.Open("sqlite3", old)
+	require.NoError(t, err)
+	_, err = d.Exec("create table messages(hexIdent text, lat float64, lon float64)")
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	assert.Equal(t, filepath.Join(dir, "2024-03-02.1.db"), write())
+	cols, err := tableColumns(old, "messages")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hexIdent", "lat", "lon"}, cols)
+
+	// As is a file that isn't a database at all.
+	at = at.AddDate(0, 0, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2024-03-03.db"), []byte("not a database, but long enough to look like one"), 0600))
+	assert.Equal(t, filepath.Join(dir, "2024-03-03.1.db"), write())
+
+	// A database without the table is used.
+	at = at.AddDate(0, 0, 1)
+	empty := filepath.Join(dir, "2024-03-04.db")
+	d, err = sql.Open("sqlite3", empty)
+	require.NoError(t, err)
+	_, err = d.Exec("create table notes(text text)")
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+	assert.Equal(t, empty, write())
+	assert.Equal(t, 1, count(empty))
+}
@@ -1,62 +1,52 @@
 package main
 
 import (
-	"bufio"
-	"encoding/hex"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"io"
-	"net"
+	"context"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
-	"dump1090-proxy/beast"
+	"dump1090-proxy/capture"
+	"dump1090-proxy/proxy"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	listenAddress          = kingpin.Flag("listen-address", "Listen address").Default("localhost:30005").String()
-	remotes                = kingpin.Flag("remote", "Remote server(s) to connect to").Required().TCPList()
+	listenAddresses        = kingpin.Flag("listen-address", "Listen address, optionally followed by ?option=value&... (may be repeated)").Default("localhost:30005").Strings()
+	remoteAddresses        = kingpin.Flag("remote", "Remote server(s) to connect to").Required().Strings()
 	dumpMessages           = kingpin.Flag("dumpMessages", "Hex-dump all messages").Bool()
+	flushInterval          = kingpin.Flag("compress.flush-interval", "How often to flush compressed client connections.").Default("250ms").Duration()
 	webListenAddress       = kingpin.Flag("web.listen-address", "Address on which to expose metrics.").Default(":9798").String()
 	metricsEndpoint        = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+	dataPath               = kingpin.Flag("web.data-path", "Path under which to expose aircraft.json and receiver.json.").Default("/data").String()
+	receiverLat            = kingpin.Flag("receiver.lat", "Latitude reported in receiver.json, to centre maps, and used to locate aircraft on the ground.").Float64()
+	receiverLon            = kingpin.Flag("receiver.lon", "Longitude reported in receiver.json, to centre maps, and used to locate aircraft on the ground.").Float64()
+	websocketPath          = kingpin.Flag("web.websocket-path", "Path under which to stream live aircraft updates over a websocket.").Default("/ws").String()
+	framesPath             = kingpin.Flag("web.frames-path", "Path under which to stream frames as Server-Sent Events.").Default("/frames").String()
+	healthPath             = kingpin.Flag("web.health-path", "Path of the liveness endpoint.").Default("/healthz").String()
+	readyPath              = kingpin.Flag("web.ready-path", "Path of the readiness endpoint.").Default("/readyz").String()
+	maxStall               = kingpin.Flag("health.max-stall", "Report unhealthy if the distributor loop has not run for this long.").Default("30s").Duration()
+	readyMinRemotes        = kingpin.Flag("ready.min-remotes", "Report ready only when at least this many remotes are active.").Default("1").Int()
+	readyMaxSilence        = kingpin.Flag("ready.max-silence", "A remote is not active if no frame has been received from it for this long.").Default("60s").Duration()
+	failoverMaxSilence     = kingpin.Flag("failover.max-silence", "Fail over from a group's active remote if it sends nothing for this long.").Default("30s").Duration()
+	recordDir              = kingpin.Flag("record.dir", "Directory in which to write capture files of remotes with the record option.").String()
+	recordAll              = kingpin.Flag("record.all", "Record frames from all remotes.").Bool()
+	recordMaxSize          = kingpin.Flag("record.max-size", "Start a new capture file when the current one reaches this size.").Default("64MB").Bytes()
+	recordInterval         = kingpin.Flag("record.interval", "Start a new capture file at multiples of this interval.").Default("1h").Duration()
+	recordMaxFiles         = kingpin.Flag("record.max-files", "Number of capture files to keep, or 0 for no limit.").Default("0").Int()
+	recordMaxAge           = kingpin.Flag("record.max-age", "Delete capture files this long after they were last written, or 0 to keep them.").Default("168h").Duration()
+	aircraftExpiry         = kingpin.Flag("aircraft.expiry", "How long to remember an aircraft after its last message.").Default("60s").Duration()
 	disableExporterMetrics = kingpin.Flag(
 		"web.disable-exporter-metrics",
 		"TODO - not implemented. Exclude standard runtime metrics (promhttp_*, process_*, go_*).",
 	).Bool()
 )
 
-// Metrics
-var (
-	messagesRead = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "messages_read",
-		Help: "The total number of dump1090 messages read from source",
-	})
-	messagesWritten = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "messages_written",
-		Help: "The total number of dump1090 messages written to clients",
-	})
-	inboundConnections = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "inbound_connections",
-		Help: "Number of inbound connections",
-	})
-	outboundConnections = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "outbound_connections",
-		Help: "Number of outbound connections",
-	})
-	ioErrorCounter = promauto.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "ioerrors_total",
-			Help: `Total IO errors`,
-		},
-		[]string{"op"},
-	)
-)
-
 func main() {
 	kingpin.Version("dev")
 	kingpin.HelpFlag.Short('h')
@@ -65,163 +55,55 @@ func main() {
 
 	logger := log.NewLogfmtLogger(os.Stderr)
 
-	listener, err := net.Listen("tcp", *listenAddress)
+	p, err := proxy.New(proxy.Options{
+		Listeners:          *listenAddresses,
+		Remotes:            *remoteAddresses,
+		Logger:             logger,
+		Registerer:         prometheus.DefaultRegisterer,
+		DumpMessages:       *dumpMessages,
+		FlushInterval:      *flushInterval,
+		FailoverMaxSilence: *failoverMaxSilence,
+		AircraftExpiry:     *aircraftExpiry,
+		Record: capture.Options{
+			Dir:      *recordDir,
+			MaxSize:  int64(*recordMaxSize),
+			Interval: *recordInterval,
+			MaxFiles: *recordMaxFiles,
+			MaxAge:   *recordMaxAge,
+		},
+		RecordAll:       *recordAll,
+		ReceiverLat:     *receiverLat,
+		ReceiverLon:     *receiverLon,
+		DataPath:        *dataPath,
+		WebsocketPath:   *websocketPath,
+		FramesPath:      *framesPath,
+		HealthPath:      *healthPath,
+		ReadyPath:       *readyPath,
+		MaxStall:        *maxStall,
+		ReadyMinRemotes: *readyMinRemotes,
+		ReadyMaxSilence: *readyMaxSilence,
+	})
 	if err != nil {
-		panic(err)
+		kingpin.Fatalf("%v", err)
 	}
 
-	go metricServer()
-	runProxy(logger, []*net.TCPListener{listener.(*net.TCPListener)}, *remotes)
+	go metricServer(p)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := p.Run(ctx); err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
+	}
+	level.Info(logger).Log("action", "stopped")
 }
 
-func metricServer() {
+func metricServer(p *proxy.Proxy) {
 	http.Handle(*metricsEndpoint, promhttp.Handler())
+	http.Handle("/", p.Handler())
 	err := http.ListenAndServe(*webListenAddress, nil)
 	if err != nil {
 		panic(err)
 	}
 }
-
-func runProxy(logger log.Logger, listeners []*net.TCPListener, remotes []*net.TCPAddr) {
-	newConnection := make(chan *net.TCPConn, 4)
-	for _, l := range listeners {
-		go runListener(logger, l, newConnection)
-	}
-
-	newMessage := make(chan []byte, 16)
-	for _, r := range remotes {
-		go runRemote(logger, r, newMessage)
-	}
-
-	clients := make(map[*net.TCPConn]struct{})
-
-	for {
-		select {
-		case c := <-newConnection:
-			level.Info(logger).Log("new_conn", c.RemoteAddr())
-			clients[c] = struct{}{}
-			c.CloseRead()
-			c.SetKeepAlive(true)
-			c.SetKeepAlivePeriod(time.Minute)
-		case m := <-newMessage:
-			if *dumpMessages {
-				level.Debug(logger).Log("message", hex.EncodeToString(m))
-			}
-
-			for c := range clients {
-				c.SetWriteDeadline(time.Now().Add(2 * time.Second))
-				_, err := c.Write(m)
-				if err != nil {
-					ioError(logger, c.RemoteAddr(), "write", err)
-					delete(clients, c)
-					c.Close()
-					continue
-				}
-			}
-
-			messagesWritten.Inc()
-		}
-
-		inboundConnections.Set(float64(len(clients)))
-	}
-}
-
-func runListener(logger log.Logger, l *net.TCPListener, ch chan<- *net.TCPConn) {
-	defer l.Close()
-	for {
-		conn, err := l.AcceptTCP()
-		if err != nil {
-			level.Error(logger).Log("listener", l.Addr(), "err", err)
-			time.Sleep(time.Second)
-			continue
-		}
-
-		ch <- conn
-	}
-}
-
-func runRemote(logger log.Logger, addr *net.TCPAddr, ch chan<- []byte) {
-	backoff := time.Duration(0)
-	lastErrorLog := time.Time{}
-
-	for {
-		time.Sleep(backoff)
-
-		if time.Now().After(lastErrorLog.Add(time.Hour)) {
-			level.Info(logger).Log("addr", addr, "action", "connecting")
-		}
-
-		conn, err := net.DialTCP("tcp", nil, addr)
-		if err != nil {
-			if time.Now().After(lastErrorLog.Add(time.Hour)) {
-				level.Error(logger).Log("addr", addr, "err", err)
-				lastErrorLog = time.Now()
-			}
-
-			backoff = (time.Second + backoff) * 2
-			if backoff > time.Minute {
-				backoff = time.Minute
-			}
-
-			continue
-		}
-
-		level.Info(logger).Log("addr", addr, "action", "connected")
-		backoff = time.Duration(0)
-		runRemoteConnection(logger, conn, ch)
-	}
-}
-
-func runRemoteConnection(logger log.Logger, conn *net.TCPConn, ch chan<- []byte) {
-
-	defer conn.Close()
-	defer level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "action", "disconnected")
-
-	outboundConnections.Inc()
-	defer outboundConnections.Dec()
-
-	conn.CloseWrite()
-	conn.SetKeepAlive(true)
-	conn.SetKeepAlivePeriod(time.Minute)
-
-	var r io.Reader = conn
-	if *dumpMessages {
-		r = NewLoggingReader(r, os.Stderr)
-	}
-
-	br := bufio.NewReader(r)
-
-	seenFirstMessage := false
-	for {
-		b, err := beast.ReadMessage(br)
-		if err, ok := err.(beast.InvalidMessage); ok {
-			// Don't log warning if we have just connected - may get partial messages.
-			if seenFirstMessage {
-				level.Warn(logger).Log("err", err)
-			}
-
-			// ReadMessage will have consumed at least one byte, so try again with remaining buffer
-			continue
-		}
-
-		if err != nil {
-			ioError(logger, conn.RemoteAddr(), "read", err)
-			break
-		}
-
-		if !seenFirstMessage {
-			level.Info(logger).Log("addr", conn.RemoteAddr().String(), "action", "seenFirstMessage")
-		}
-
-		seenFirstMessage = true
-		messagesRead.Inc()
-		ch <- b
-	}
-}
-
-func ioError(logger log.Logger, addr interface{}, op string, err error) {
-	level.Error(logger).Log("addr", addr, "op", op, "err", err)
-	ioErrorCounter.With(prometheus.Labels{
-		"op": op,
-	}).Inc()
-}
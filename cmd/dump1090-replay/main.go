@@ -0,0 +1,330 @@
+// dump1090-replay serves capture files written by dump1090-proxy, or raw beast
+// dumps, as a beast TCP server. Each client receives its own replay from the
+// beginning.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/capture"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	listenAddress = kingpin.Flag("listen-address", "Address on which to serve beast.").Default("localhost:30005").String()
+	speed         = kingpin.Flag("speed", "Replay speed relative to real time; 0 replays as fast as possible.").Default("1").Float64()
+	loop          = kingpin.Flag("loop", "Start again from the beginning after the last frame.").Bool()
+	from          = kingpin.Flag("from", "Skip frames before this time (RFC3339, ignored for raw dumps) or offset from the start (e.g. 10m).").String()
+	to            = kingpin.Flag("to", "Stop at this time (RFC3339, ignored for raw dumps) or offset from the start (e.g. 20m).").String()
+	maxGap        = kingpin.Flag("max-gap", "Shorten silences longer than this, or 0 to keep them.").Default("0s").Duration()
+	files         = kingpin.Arg("file", "Capture files or raw beast dumps to replay, in order.").Required().ExistingFiles()
+
+	logger log.Logger
+)
+
+// mlatClock is the rate of beast MLAT timestamps, used to pace raw dumps.
+const mlatClock = 12e6
+
+// errWindowEnd stops a replay when it reaches --to.
+var errWindowEnd = errors.New("end of window")
+
+func main() {
+	kingpin.Version("dev")
+	kingpin.HelpFlag.Short('h')
+	kingpin.CommandLine.UsageWriter(os.Stdout)
+	kingpin.Parse()
+
+	logger = log.NewLogfmtLogger(os.Stderr)
+
+	if *speed < 0 {
+		kingpin.Fatalf("--speed must not be negative")
+	}
+	start, err := parseBound(*from)
+	if err != nil {
+		kingpin.Fatalf("--from: %v", err)
+	}
+	end, err := parseBound(*to)
+	if err != nil {
+		kingpin.Fatalf("--to: %v", err)
+	}
+
+	l, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		level.Error(logger).Log("addr", *listenAddress, "err", err)
+		os.Exit(1)
+	}
+	level.Info(logger).Log("addr", *listenAddress, "action", "listening")
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			level.Error(logger).Log("addr", *listenAddress, "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		go serve(conn, start, end)
+	}
+}
+
+func serve(conn net.Conn, start, end bound) {
+	defer conn.Close()
+	level.Info(logger).Log("addr", conn.RemoteAddr().String(), "action", "connected")
+
+	w := bufio.NewWriter(conn)
+	for {
+		sent, err := replay(w, start, end)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+		if sent == 0 {
+			level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "err", "no frames to replay")
+			return
+		}
+
+		level.Info(logger).Log("addr", conn.RemoteAddr().String(), "action", "replayed", "frames", sent)
+		if !*loop {
+			return
+		}
+	}
+}
+
+// bound is one end of the replay window: either an absolute time or an
+// offset from the start of the replay.
+type bound struct {
+	at     time.Time
+	offset time.Duration
+}
+
+func parseBound(s string) (bound, error) {
+	if s == "" {
+		return bound{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return bound{at: t}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return bound{}, fmt.Errorf("%q is neither a time nor a duration", s)
+	}
+	return bound{offset: d}, nil
+}
+
+func (b bound) set() bool {
+	return !b.at.IsZero() || b.offset != 0
+}
+
+// appliesTo reports whether the bound can be applied to a frame received at
+// t. Frames from raw dumps have no time, so absolute bounds are ignored for
+// them.
+func (b bound) appliesTo(t time.Time) bool {
+	return b.set() && (b.at.IsZero() || !t.IsZero())
+}
+
+// before reports whether a frame received at t, offset from the start of the
+// replay, comes before the bound.
+func (b bound) before(t time.Time, offset time.Duration) bool {
+	if !b.at.IsZero() {
+		return t.Before(b.at)
+	}
+	return offset < b.offset
+}
+
+// event is a single frame to replay.
+type event struct {
+	// time is when the frame was received, if known.
+	time time.Time
+	// delay is the time since the previous frame.
+	delay time.Duration
+	msg   []byte
+}
+
+// replay writes one pass through all the files to w, pacing them according
+// to --speed, and returns the number of frames written.
+func replay(w *bufio.Writer, start, end bound) (int, error) {
+	var (
+		sent     int
+		offset   time.Duration
+		base     time.Duration
+		baseTime time.Time
+		warned   bool
+	)
+
+	send := func(e event) error {
+		d := e.delay
+		if *maxGap > 0 && d > *maxGap {
+			d = *maxGap
+		}
+		offset += d
+
+		if e.time.IsZero() && (!start.at.IsZero() || !end.at.IsZero()) && !warned {
+			level.Warn(logger).Log("msg", "raw dumps have no times, so absolute --from and --to are ignored for them")
+			warned = true
+		}
+
+		if start.appliesTo(e.time) && start.before(e.time, offset) {
+			return nil
+		}
+		if end.appliesTo(e.time) && !end.before(e.time, offset) {
+			return errWindowEnd
+		}
+
+		if sent == 0 {
+			base, baseTime = offset, time.Now()
+		}
+
+		if *speed > 0 {
+			due := baseTime.Add(time.Duration(float64(offset-base) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				// Don't hold frames back while we wait.
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				time.Sleep(wait)
+			}
+		}
+
+		sent++
+		_, err := w.Write(e.msg)
+		return err
+	}
+
+	var prev time.Time
+	for _, name := range *files {
+		err := readFile(name, &prev, send)
+		if err == errWindowEnd {
+			break
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// readFile calls fn for each frame in a capture file or raw beast dump. prev
+// is the time of the previous frame from a capture file, carried between
+// files so that the gap between them is kept.
+func readFile(name string, prev *time.Time, fn func(event) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	first, err := r.Peek(1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if first[0] == 0x1a {
+		return readRaw(name, r, fn)
+	}
+	return readCapture(name, r, prev, fn)
+}
+
+// skipToFrame discards data up to the start of the next frame: an escape
+// that isn't followed by another.
+func skipToFrame(r *bufio.Reader) error {
+	for {
+		b, err := r.Peek(2)
+		if len(b) < 2 {
+			return err
+		}
+		switch {
+		case b[0] != 0x1a:
+			r.Discard(1)
+		case b[1] == 0x1a:
+			// An escaped 0x1a in the middle of a frame.
+			r.Discard(2)
+		default:
+			return nil
+		}
+	}
+}
+
+func readCapture(name string, r io.Reader, prev *time.Time, fn func(event) error) error {
+	cr := capture.NewReader(r)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		var delay time.Duration
+		if !prev.IsZero() && rec.Time.After(*prev) {
+			delay = rec.Time.Sub(*prev)
+		}
+		*prev = rec.Time
+
+		if err := fn(event{time: rec.Time, delay: delay, msg: rec.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+// readRaw paces frames in a raw beast dump by their MLAT timestamps. Frames
+// without a timestamp, or whose timestamp goes backwards (e.g. because they
+// came from a different receiver), are sent without delay.
+func readRaw(name string, r *bufio.Reader, fn func(event) error) error {
+	var prev uint64
+	for {
+		msg, err := beast.ReadMessage(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			// Corrupt data, e.g. an unescaped escape. As the proxy would,
+			// carry on from the next frame.
+			level.Warn(logger).Log("file", name, "err", err)
+			if err := skipToFrame(r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		f, err := beast.ParseFrame(msg)
+		if err != nil {
+			level.Warn(logger).Log("file", name, "err", err)
+			continue
+		}
+
+		var delay time.Duration
+		if f.Type != beast.ReceiverID && f.Timestamp != 0 {
+			if prev != 0 && f.Timestamp > prev {
+				delay = time.Duration(float64(f.Timestamp-prev) / mlatClock * float64(time.Second))
+			}
+			prev = f.Timestamp
+		}
+
+		if err := fn(event{delay: delay, msg: msg}); err != nil {
+			return err
+		}
+	}
+}
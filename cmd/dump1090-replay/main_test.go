@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/capture"
+	"dump1090-proxy/modes"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// frame returns the i'th test frame, stamped i seconds after the first.
+func frame(i int) []byte {
+	return beast.Frame{
+		Type:      beast.ModeSLong,
+		Timestamp: uint64(i+1) * mlatClock,
+		Signal:    0x80,
+		Data:      modes.EncodeIdentification(0x400000+uint32(i), "A3", "TEST"),
+	}.Bytes()
+}
+
+// writeFiles writes the first n test frames, received a second apart, to a
+// capture file and to a raw dump.
+func writeFiles(t *testing.T, n int) (captureFile, rawFile string) {
+	dir := t.TempDir()
+
+	var c, raw []byte
+	for i := 0; i < n; i++ {
+		c = capture.AppendRecord(c, capture.Record{Time: start.Add(time.Duration(i) * time.Second), Message: frame(i)})
+		raw = append(raw, frame(i)...)
+	}
+
+	captureFile, rawFile = filepath.Join(dir, "test.capture"), filepath.Join(dir, "test.bin")
+	require.NoError(t, os.WriteFile(captureFile, c, 0o644))
+	require.NoError(t, os.WriteFile(rawFile, raw, 0o644))
+	return captureFile, rawFile
+}
+
+func TestReplayWindow(t *testing.T) {
+	logger = log.NewNopLogger()
+	*speed = 0
+	captureFile, rawFile := writeFiles(t, 5)
+
+	tests := []struct {
+		name     string
+		file     string
+		from, to string
+		want     []int
+	}{
+		{name: "capture", file: captureFile, want: []int{0, 1, 2, 3, 4}},
+		{name: "capture times", file: captureFile, from: "2024-03-01T12:00:01Z", to: "2024-03-01T12:00:03Z", want: []int{1, 2}},
+		{name: "capture offsets", file: captureFile, from: "1s", to: "3s", want: []int{1, 2}},
+		{name: "raw", file: rawFile, want: []int{0, 1, 2, 3, 4}},
+		// Raw dumps have no times, so absolute bounds are ignored.
+		{name: "raw times", file: rawFile, from: "2024-03-01T12:00:01Z", to: "2024-03-01T12:00:03Z", want: []int{0, 1, 2, 3, 4}},
+		{name: "raw offsets", file: rawFile, from: "1s", to: "3s", want: []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*files = []string{tt.file}
+			from, err := parseBound(tt.from)
+			require.NoError(t, err)
+			to, err := parseBound(tt.to)
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			w := bufio.NewWriter(&out)
+			sent, err := replay(w, from, to)
+			require.NoError(t, err)
+			require.NoError(t, w.Flush())
+
+			var want []byte
+			for _, i := range tt.want {
+				want = append(want, frame(i)...)
+			}
+			assert.Equal(t, len(tt.want), sent)
+			assert.Equal(t, want, out.Bytes())
+		})
+	}
+}
+
+func TestReplayCorruptRaw(t *testing.T) {
+	logger = log.NewNopLogger()
+	*speed = 0
+
+	// The second frame is cut short, so the escape starting the third looks
+	// like an unescaped escape within it, and there's junk before the last.
+	var raw []byte
+	raw = append(raw, frame(0)...)
+	raw = append(raw, frame(1)[:5]...)
+	raw = append(raw, frame(2)...)
+	raw = append(raw, frame(3)...)
+	raw = append(raw, "junk\x1a\x1a"...)
+	raw = append(raw, frame(4)...)
+	rawFile := filepath.Join(t.TempDir(), "corrupt.bin")
+	require.NoError(t, os.WriteFile(rawFile, raw, 0o644))
+
+	*files = []string{rawFile}
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	sent, err := replay(w, bound{}, bound{})
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	// The third frame is lost with the second, but the replay carries on.
+	var want []byte
+	for _, i := range []int{0, 3, 4} {
+		want = append(want, frame(i)...)
+	}
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, want, out.Bytes())
+}
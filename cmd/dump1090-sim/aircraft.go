@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+)
+
+var airlines = []string{"BAW", "EZY", "RYR", "DLH", "KLM", "AFR", "UAE", "VIR", "SHT", "TOM"}
+
+// aircraft is a simulated aircraft flying a series of straight legs within
+// the simulated area.
+type aircraft struct {
+	icao     uint32
+	callsign string
+	category string
+	squawk   string
+
+	lat, lon     float64
+	altitude     float64 // Feet.
+	targetAlt    float64
+	verticalRate float64 // Feet per minute.
+	speed        float64 // Knots.
+	track        float64 // Degrees.
+	targetTrack  float64
+
+	odd  bool
+	next [numKinds]time.Time
+}
+
+// Kinds of message, each sent at its own rate.
+const (
+	identification = iota
+	position
+	velocity
+	allCall
+	modeAC
+	numKinds
+)
+
+type transmission struct {
+	frameType byte
+	data      []byte
+}
+
+func newAircraft(rnd *rand.Rand, now time.Time) *aircraft {
+	r := *radius * math.Sqrt(rnd.Float64())
+	theta := rnd.Float64() * 2 * math.Pi
+	lat := *centreLat + r*math.Cos(theta)/111.2
+	lon := *centreLon + r*math.Sin(theta)/(111.2*math.Cos(*centreLat*math.Pi/180))
+
+	a := &aircraft{
+		icao:     uint32(rnd.Intn(0xfffffe) + 1),
+		callsign: fmt.Sprintf("%s%d", airlines[rnd.Intn(len(airlines))], rnd.Intn(9000)+100),
+		category: "A3",
+		squawk:   octal(rnd),
+		lat:      lat,
+		lon:      lon,
+		altitude: float64(1000 * (rnd.Intn(38) + 3)),
+		speed:    250 + rnd.Float64()*230,
+		track:    rnd.Float64() * 360,
+	}
+	a.targetAlt = a.altitude
+	a.targetTrack = a.track
+
+	for k := range a.next {
+		a.next[k] = now.Add(time.Duration(rnd.Float64() * float64(time.Second)))
+	}
+
+	return a
+}
+
+// fly advances the aircraft by dt, turning back towards the centre of the
+// area when it reaches the edge and occasionally changing level.
+func (a *aircraft) fly(rnd *rand.Rand, dt time.Duration) {
+	hours := dt.Hours()
+
+	// Standard rate turn: 3 degrees per second.
+	turn := math.Mod(a.targetTrack-a.track+540, 360) - 180
+	maxTurn := 3 * dt.Seconds()
+	a.track = math.Mod(a.track+math.Max(-maxTurn, math.Min(maxTurn, turn))+360, 360)
+
+	rad := a.track * math.Pi / 180
+	km := a.speed * 1.852 * hours
+	a.lat += km * math.Cos(rad) / 111.2
+	a.lon += km * math.Sin(rad) / (111.2 * math.Cos(a.lat*math.Pi/180))
+
+	switch {
+	case a.altitude < a.targetAlt-50:
+		a.verticalRate = 1500
+	case a.altitude > a.targetAlt+50:
+		a.verticalRate = -1500
+	default:
+		a.verticalRate = 0
+		if rnd.Float64() < hours*2 {
+			a.targetAlt = float64(1000 * (rnd.Intn(38) + 3))
+		}
+	}
+	a.altitude += a.verticalRate * dt.Minutes()
+
+	if distance(a.lat, a.lon, *centreLat, *centreLon) > *radius && math.Abs(turn) < 1 {
+		a.targetTrack = math.Mod(bearing(a.lat, a.lon, *centreLat, *centreLon)+rnd.Float64()*60-30+360, 360)
+	}
+}
+
+// transmit returns the messages due from the aircraft at now.
+func (a *aircraft) transmit(rnd *rand.Rand, now time.Time) []transmission {
+	var msgs []transmission
+
+	for k, rate := range [numKinds]float64{
+		identification: *identificationRate,
+		position:       *positionRate,
+		velocity:       *velocityRate,
+		allCall:        *allCallRate,
+		modeAC:         *modeACRate,
+	} {
+		if rate <= 0 || now.Before(a.next[k]) {
+			continue
+		}
+
+		// Jitter the interval so that transmissions from different aircraft
+		// don't fall into step.
+		interval := time.Duration((0.5 + rnd.Float64()) / rate * float64(time.Second))
+		a.next[k] = now.Add(interval)
+
+		msgs = append(msgs, a.message(k))
+	}
+
+	return msgs
+}
+
+func (a *aircraft) message(kind int) transmission {
+	long := func(data []byte) transmission {
+		return transmission{frameType: beast.ModeSLong, data: data}
+	}
+
+	switch kind {
+	case identification:
+		return long(modes.EncodeIdentification(a.icao, a.category, a.callsign))
+	case position:
+		a.odd = !a.odd
+		return long(modes.EncodeAirbornePosition(a.icao, int(a.altitude), modes.EncodeCPR(a.lat, a.lon, a.odd)))
+	case velocity:
+		return long(modes.EncodeVelocity(a.icao, a.speed, a.track, int(a.verticalRate)))
+	case allCall:
+		return transmission{frameType: beast.ModeSShort, data: modes.EncodeAllCall(a.icao, false)}
+	default:
+		// Mode A/C replies carry the code as four octal digits, one per nibble.
+		var code uint32
+		fmt.Sscanf(a.squawk, "%x", &code)
+		return transmission{frameType: beast.ModeAC, data: []byte{byte(code >> 8), byte(code)}}
+	}
+}
+
+// signal returns a signal level that falls off with distance from the centre
+// of the area, with some noise.
+func (a *aircraft) signal(rnd *rand.Rand) byte {
+	d := distance(a.lat, a.lon, *centreLat, *centreLon) / *radius
+	s := 220*math.Exp(-1.5*d) + rnd.Float64()*20 - 10
+	return byte(math.Max(10, math.Min(255, s)))
+}
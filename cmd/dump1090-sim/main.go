@@ -0,0 +1,185 @@
+// dump1090-sim generates synthetic ADS-B traffic and serves it in beast
+// format, for load and regression testing without a receiver.
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"dump1090-proxy/beast"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	listenAddress      = kingpin.Flag("listen-address", "Address on which to serve beast.").Default("localhost:30005").String()
+	aircraftCount      = kingpin.Flag("aircraft", "Number of aircraft to simulate.").Default("20").Int()
+	centreLat          = kingpin.Flag("lat", "Latitude of the centre of the simulated area.").Default("51.47").Float64()
+	centreLon          = kingpin.Flag("lon", "Longitude of the centre of the simulated area.").Default("-0.45").Float64()
+	radius             = kingpin.Flag("radius", "Radius of the simulated area, in km.").Default("150").Float64()
+	identificationRate = kingpin.Flag("rate.identification", "Identification messages per aircraft per second.").Default("0.2").Float64()
+	positionRate       = kingpin.Flag("rate.position", "Airborne position messages per aircraft per second.").Default("2").Float64()
+	velocityRate       = kingpin.Flag("rate.velocity", "Velocity messages per aircraft per second.").Default("2").Float64()
+	allCallRate        = kingpin.Flag("rate.all-call", "DF11 all-call replies per aircraft per second.").Default("1").Float64()
+	modeACRate         = kingpin.Flag("rate.modeac", "Mode A/C replies per aircraft per second.").Default("0.5").Float64()
+	seed               = kingpin.Flag("seed", "Random seed, for reproducible traffic; 0 picks one.").Int64()
+
+	logger log.Logger
+)
+
+const (
+	// step is how often the simulation advances.
+	step = 50 * time.Millisecond
+	// mlatClock is the rate of beast MLAT timestamps.
+	mlatClock = 12e6
+	// clientBuffer is the number of steps a client may fall behind before it
+	// is disconnected.
+	clientBuffer = 100
+)
+
+func main() {
+	kingpin.Version("dev")
+	kingpin.HelpFlag.Short('h')
+	kingpin.CommandLine.UsageWriter(os.Stdout)
+	kingpin.Parse()
+
+	logger = log.NewLogfmtLogger(os.Stderr)
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	level.Info(logger).Log("seed", *seed)
+
+	l, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		level.Error(logger).Log("addr", *listenAddress, "err", err)
+		os.Exit(1)
+	}
+	level.Info(logger).Log("addr", *listenAddress, "action", "listening")
+
+	clients := newClients()
+	go simulate(rand.New(rand.NewSource(*seed)), clients)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			level.Error(logger).Log("addr", *listenAddress, "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		go clients.serve(conn)
+	}
+}
+
+// clients broadcasts the generated frames to every connected client.
+type clients struct {
+	mu   sync.Mutex
+	subs map[net.Conn]chan []byte
+}
+
+func newClients() *clients {
+	return &clients{subs: make(map[net.Conn]chan []byte)}
+}
+
+func (c *clients) serve(conn net.Conn) {
+	level.Info(logger).Log("addr", conn.RemoteAddr().String(), "action", "connected")
+	ch := make(chan []byte, clientBuffer)
+
+	c.mu.Lock()
+	c.subs[conn] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, conn)
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	for b := range ch {
+		if _, err := conn.Write(b); err != nil {
+			level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+	}
+
+	level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "err", "too slow")
+}
+
+func (c *clients) broadcast(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for conn, ch := range c.subs {
+		select {
+		case ch <- b:
+		default:
+			// Closing the channel makes serve disconnect the client.
+			close(ch)
+			delete(c.subs, conn)
+		}
+	}
+}
+
+func simulate(rnd *rand.Rand, c *clients) {
+	start := time.Now()
+	sky := make([]*aircraft, *aircraftCount)
+	for i := range sky {
+		sky[i] = newAircraft(rnd, start)
+	}
+
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+
+	last := start
+	for now := range ticker.C {
+		dt := now.Sub(last)
+		last = now
+
+		var buff []byte
+		for _, a := range sky {
+			a.fly(rnd, dt)
+			for _, msg := range a.transmit(rnd, now) {
+				f := beast.Frame{
+					Type:      msg.frameType,
+					Timestamp: uint64(now.Sub(start).Seconds()*mlatClock) & (1<<48 - 1),
+					Signal:    a.signal(rnd),
+					Data:      msg.data,
+				}
+				buff = append(buff, f.Bytes()...)
+			}
+		}
+
+		if len(buff) > 0 {
+			c.broadcast(buff)
+		}
+	}
+}
+
+// distance returns the approximate distance in km between two nearby points.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const kmPerDegree = 111.2
+	dLat := lat2 - lat1
+	dLon := (lon2 - lon1) * math.Cos((lat1+lat2)/2*math.Pi/180)
+	return math.Hypot(dLat, dLon) * kmPerDegree
+}
+
+// bearing returns the approximate initial bearing in degrees from one point to
+// another nearby.
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := lat2 - lat1
+	dLon := (lon2 - lon1) * math.Cos((lat1+lat2)/2*math.Pi/180)
+	return math.Mod(math.Atan2(dLon, dLat)*180/math.Pi+360, 360)
+}
+
+// octal returns a random 4-digit octal code, such as a squawk.
+func octal(rnd *rand.Rand) string {
+	return fmt.Sprintf("%04o", rnd.Intn(010000))
+}
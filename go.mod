@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/go-kit/log v0.2.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/prometheus/client_golang v1.13.0
 	github.com/stretchr/testify v1.7.1
@@ -0,0 +1,32 @@
+package modes
+
+// TypeCode returns the ADS-B type code of a DF17 or DF18 extended squitter,
+// or 0 for any other message.
+func TypeCode(data []byte) int {
+	df := DownlinkFormat(data)
+	if (df != 17 && df != 18) || len(data) != LongLength {
+		return 0
+	}
+
+	return int(data[4] >> 3)
+}
+
+// Position extracts the position report from an airborne or surface position
+// message. ok is false for any other message.
+func Position(data []byte) (cpr CPR, ok bool) {
+	tc := TypeCode(data)
+	switch {
+	case tc >= 5 && tc <= 8:
+		cpr.Surface = true
+	case tc >= 9 && tc <= 18, tc >= 20 && tc <= 22:
+	default:
+		return CPR{}, false
+	}
+
+	me := data[4:11]
+	cpr.Odd = me[2]&0x04 != 0
+	cpr.Lat = uint32(me[2]&0x03)<<15 | uint32(me[3])<<7 | uint32(me[4])>>1
+	cpr.Lon = uint32(me[4]&0x01)<<16 | uint32(me[5])<<8 | uint32(me[6])
+
+	return cpr, true
+}
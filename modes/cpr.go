@@ -0,0 +1,190 @@
+package modes
+
+import "math"
+
+const (
+	// nz is the number of latitude zones between the equator and a pole.
+	nz = 15
+	// cprMax is the scale of the 17-bit encoded latitude and longitude.
+	cprMax = 1 << 17
+)
+
+// CPR is a Compact Position Report, as carried in airborne and surface
+// position messages. A single report is ambiguous: it must be combined with a
+// report of the opposite parity (DecodeGlobal, DecodeGlobalSurface) or with a
+// nearby reference position (DecodeLocal).
+type CPR struct {
+	Odd     bool
+	Surface bool
+	Lat     uint32
+	Lon     uint32
+}
+
+// NL returns the number of longitude zones at the given latitude.
+func NL(lat float64) int {
+	lat = math.Abs(lat)
+	switch {
+	case lat == 0:
+		return 59
+	case lat == 87:
+		return 2
+	case lat > 87:
+		return 1
+	}
+
+	a := 1 - math.Cos(math.Pi/(2*nz))
+	b := math.Cos(math.Pi / 180 * lat)
+	return int(math.Floor(2 * math.Pi / math.Acos(1-a/(b*b))))
+}
+
+// DecodeGlobal resolves an even/odd pair of airborne reports into an
+// unambiguous position. oddLatest says which of the two was received most
+// recently; the result is the position at that time. The reports must have
+// been received close together (within about 10 seconds) for the result to be
+// meaningful. ok is false if the pair straddles a longitude zone boundary.
+func DecodeGlobal(even, odd CPR, oddLatest bool) (lat, lon float64, ok bool) {
+	if even.Odd || !odd.Odd || even.Surface || odd.Surface {
+		return 0, 0, false
+	}
+
+	const (
+		dLatEven = 360.0 / 60
+		dLatOdd  = 360.0 / 59
+	)
+
+	latEven := float64(even.Lat) / cprMax
+	latOdd := float64(odd.Lat) / cprMax
+	lonEven := float64(even.Lon) / cprMax
+	lonOdd := float64(odd.Lon) / cprMax
+
+	j := math.Floor(59*latEven - 60*latOdd + 0.5)
+	rlatEven := dLatEven * (mod(j, 60) + latEven)
+	rlatOdd := dLatOdd * (mod(j, 59) + latOdd)
+	if rlatEven >= 270 {
+		rlatEven -= 360
+	}
+	if rlatOdd >= 270 {
+		rlatOdd -= 360
+	}
+
+	if rlatEven < -90 || rlatEven > 90 || rlatOdd < -90 || rlatOdd > 90 {
+		return 0, 0, false
+	}
+
+	nl := NL(rlatEven)
+	if nl != NL(rlatOdd) {
+		return 0, 0, false
+	}
+
+	m := math.Floor(lonEven*float64(nl-1) - lonOdd*float64(nl) + 0.5)
+	if oddLatest {
+		ni := float64(maxInt(nl-1, 1))
+		lat = rlatOdd
+		lon = (360 / ni) * (mod(m, ni) + lonOdd)
+	} else {
+		ni := float64(maxInt(nl, 1))
+		lat = rlatEven
+		lon = (360 / ni) * (mod(m, ni) + lonEven)
+	}
+
+	if lon >= 180 {
+		lon -= 360
+	}
+
+	return lat, lon, true
+}
+
+// DecodeGlobalSurface resolves an even/odd pair of surface reports, as
+// DecodeGlobal does for airborne ones. Surface reports are four times as
+// precise, so a pair leaves four possible longitudes 90 degrees apart, and
+// two latitudes, one in each hemisphere. The one nearest the reference
+// position, usually the receiver's, is chosen.
+func DecodeGlobalSurface(even, odd CPR, oddLatest bool, refLat, refLon float64) (lat, lon float64, ok bool) {
+	if even.Odd || !odd.Odd || !even.Surface || !odd.Surface {
+		return 0, 0, false
+	}
+
+	const (
+		dLatEven = 90.0 / 60
+		dLatOdd  = 90.0 / 59
+	)
+
+	latEven := float64(even.Lat) / cprMax
+	latOdd := float64(odd.Lat) / cprMax
+	lonEven := float64(even.Lon) / cprMax
+	lonOdd := float64(odd.Lon) / cprMax
+
+	j := math.Floor(59*latEven - 60*latOdd + 0.5)
+	rlatEven := dLatEven * (mod(j, 60) + latEven)
+	rlatOdd := dLatOdd * (mod(j, 59) + latOdd)
+	// These are in the northern hemisphere; the alternatives are 90 degrees
+	// south.
+	if math.Abs(rlatEven-90-refLat) < math.Abs(rlatEven-refLat) {
+		rlatEven -= 90
+		rlatOdd -= 90
+	}
+
+	nl := NL(rlatEven)
+	if nl != NL(rlatOdd) {
+		return 0, 0, false
+	}
+
+	m := math.Floor(lonEven*float64(nl-1) - lonOdd*float64(nl) + 0.5)
+	if oddLatest {
+		ni := float64(maxInt(nl-1, 1))
+		lat = rlatOdd
+		lon = (90 / ni) * (mod(m, ni) + lonOdd)
+	} else {
+		ni := float64(maxInt(nl, 1))
+		lat = rlatEven
+		lon = (90 / ni) * (mod(m, ni) + lonEven)
+	}
+
+	// Move to whichever quadrant is nearest the reference.
+	lon += 90 * math.Floor(mod(refLon-lon+45, 360)/90)
+	lon = mod(lon+180, 360) - 180
+
+	return lat, lon, true
+}
+
+// DecodeLocal resolves a single report using a reference position, which must
+// be within about 180NM (airborne) or 45NM (surface) of the true position.
+func DecodeLocal(c CPR, refLat, refLon float64) (lat, lon float64) {
+	span := 360.0
+	if c.Surface {
+		span = 90
+	}
+
+	i := 0
+	if c.Odd {
+		i = 1
+	}
+
+	cprLat := float64(c.Lat) / cprMax
+	dLat := span / float64(60-i)
+	j := math.Floor(refLat/dLat) + math.Floor(0.5+mod(refLat, dLat)/dLat-cprLat)
+	lat = dLat * (j + cprLat)
+
+	cprLon := float64(c.Lon) / cprMax
+	dLon := span / float64(maxInt(NL(lat)-i, 1))
+	m := math.Floor(refLon/dLon) + math.Floor(0.5+mod(refLon, dLon)/dLon-cprLon)
+	lon = dLon * (m + cprLon)
+
+	return lat, lon
+}
+
+// mod returns the non-negative remainder of a/b.
+func mod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r < 0 {
+		r += b
+	}
+	return r
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
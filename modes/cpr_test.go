@@ -0,0 +1,106 @@
+package modes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	evenPosition = "8d40621d58c382d690c8ac2863a7"
+	oddPosition  = "8d40621d58c386435cc412692ad6"
+)
+
+func TestPosition(t *testing.T) {
+	even, ok := Position(mustDecode(t, evenPosition))
+	assert.True(t, ok)
+	assert.Equal(t, CPR{Odd: false, Lat: 93000, Lon: 51372}, even)
+
+	odd, ok := Position(mustDecode(t, oddPosition))
+	assert.True(t, ok)
+	assert.Equal(t, CPR{Odd: true, Lat: 74158, Lon: 50194}, odd)
+
+	_, ok = Position(mustDecode(t, "8d4840d6202cc371c32ce0576098"))
+	assert.False(t, ok)
+}
+
+func TestNL(t *testing.T) {
+	assert.Equal(t, 59, NL(0))
+	assert.Equal(t, 36, NL(52.2572))
+	assert.Equal(t, 36, NL(-52.2572))
+	assert.Equal(t, 2, NL(87))
+	assert.Equal(t, 1, NL(89))
+}
+
+func TestDecodeGlobal(t *testing.T) {
+	even, _ := Position(mustDecode(t, evenPosition))
+	odd, _ := Position(mustDecode(t, oddPosition))
+
+	lat, lon, ok := DecodeGlobal(even, odd, false)
+	assert.True(t, ok)
+	assert.InDelta(t, 52.25720, lat, 0.00001)
+	assert.InDelta(t, 3.91937, lon, 0.00001)
+
+	_, _, ok = DecodeGlobal(odd, even, false)
+	assert.False(t, ok)
+}
+
+func TestDecodeLocal(t *testing.T) {
+	even, _ := Position(mustDecode(t, evenPosition))
+
+	lat, lon := DecodeLocal(even, 52.258, 3.918)
+	assert.InDelta(t, 52.25720, lat, 0.00001)
+	assert.InDelta(t, 3.91937, lon, 0.00001)
+}
+
+func TestDecodeGlobalSurface(t *testing.T) {
+	for _, p := range []struct{ lat, lon, refLat, refLon float64 }{
+		// Schiphol, heard from Delft.
+		{52.3105, 4.7683, 51.99, 4.375},
+		// Sydney, Kennedy and Los Angeles, heard from nearby.
+		{-33.9461, 151.1772, -33.8, 151.0},
+		{40.6413, -73.7781, 40.7, -74.0},
+		{33.9416, -118.4085, 34.1, -118.2},
+		// Either side of the equator and the antimeridian.
+		{0.01, 179.99, -0.2, -179.8},
+		{-0.01, -179.99, 0.2, 179.8},
+		// The reference need only be within 45 degrees.
+		{52.3105, 4.7683, 40, 40},
+	} {
+		even := EncodeSurfacePosition(0x40621d, EncodeSurfaceCPR(p.lat, p.lon, false))
+		odd := EncodeSurfacePosition(0x40621d, EncodeSurfaceCPR(p.lat, p.lon, true))
+		assert.True(t, CheckParity(even))
+
+		me, _ := Decode(even)
+		mo, _ := Decode(odd)
+		assert.True(t, me.HasPosition, "%v", p)
+		assert.True(t, me.Position.Surface, "%v", p)
+		assert.True(t, me.OnGround, "%v", p)
+
+		for _, oddLatest := range []bool{false, true} {
+			lat, lon, ok := DecodeGlobalSurface(me.Position, mo.Position, oddLatest, p.refLat, p.refLon)
+			assert.True(t, ok, "%v", p)
+			assert.InDelta(t, p.lat, lat, 0.0001, "%v", p)
+			assert.InDelta(t, p.lon, lon, 0.0001, "%v", p)
+		}
+
+		// Local decoding, with a reference close to the aircraft, agrees.
+		lat, lon := DecodeLocal(mo.Position, p.lat+0.1, p.lon-0.1)
+		assert.InDelta(t, p.lat, lat, 0.0001, "%v", p)
+		assert.InDelta(t, p.lon, lon, 0.0001, "%v", p)
+
+		// Airborne decoding can't be used for surface reports.
+		_, _, ok := DecodeGlobal(me.Position, mo.Position, true)
+		assert.False(t, ok)
+	}
+
+	// Nor surface decoding for airborne reports, or reports in the wrong
+	// order.
+	even, _ := Position(mustDecode(t, evenPosition))
+	odd, _ := Position(mustDecode(t, oddPosition))
+	_, _, ok := DecodeGlobalSurface(even, odd, true, 52, 4)
+	assert.False(t, ok)
+	even, odd = EncodeSurfaceCPR(52.3105, 4.7683, false), EncodeSurfaceCPR(52.3105, 4.7683, true)
+	_, _, ok = DecodeGlobalSurface(odd, even, true, 52, 4)
+	assert.False(t, ok)
+}
@@ -0,0 +1,319 @@
+package modes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const callsignChars = "#ABCDEFGHIJKLMNOPQRSTUVWXYZ##### ###############0123456789######"
+
+// Message holds the fields decoded from a single Mode S message. Most
+// messages carry only a few fields; the Has flags (or empty strings) show
+// which are present.
+type Message struct {
+	DF       int
+	ICAO     uint32
+	TypeCode int
+
+	Callsign string
+	Category string
+	Squawk   string
+
+	HasAltitude bool
+	Altitude    int // Barometric, feet.
+
+	HasOnGround bool
+	OnGround    bool
+
+	HasVelocity bool
+	GroundSpeed float64 // Knots.
+	Track       float64 // Degrees clockwise from true north.
+
+	HasVerticalRate bool
+	VerticalRate    int // Feet per minute.
+
+	HasPosition bool
+	Position    CPR
+}
+
+// Decode extracts what it can from a Mode S message. ok is false if the
+// message does not carry an address. The parity is not checked: see
+// CheckParity.
+func Decode(data []byte) (m Message, ok bool) {
+	m.ICAO, ok = ICAO(data)
+	if !ok {
+		return m, false
+	}
+
+	m.DF = DownlinkFormat(data)
+
+	switch m.DF {
+	case 0, 16:
+		m.Altitude, m.HasAltitude = altitude13(ac13(data))
+	case 4, 20:
+		m.Altitude, m.HasAltitude = altitude13(ac13(data))
+		m.OnGround, m.HasOnGround = flightStatus(data)
+	case 5, 21:
+		m.Squawk = squawk(ac13(data))
+		m.OnGround, m.HasOnGround = flightStatus(data)
+	case 11:
+		m.OnGround, m.HasOnGround = capability(data)
+	case 17, 18:
+		if m.DF == 17 {
+			m.OnGround, m.HasOnGround = capability(data)
+		}
+		m.decodeExtendedSquitter(data)
+	}
+
+	return m, true
+}
+
+func (m *Message) decodeExtendedSquitter(data []byte) {
+	me := data[4:11]
+	m.TypeCode = int(me[0] >> 3)
+	tc := m.TypeCode
+
+	switch {
+	case tc >= 1 && tc <= 4:
+		var sb strings.Builder
+		for i := 0; i < 8; i++ {
+			sb.WriteByte(callsignChars[bits(me, 9+6*i, 14+6*i)])
+		}
+		m.Callsign = strings.TrimRight(sb.String(), " #")
+		if ca := me[0] & 0x07; ca != 0 {
+			m.Category = fmt.Sprintf("%c%d", 'A'+4-tc, ca)
+		}
+
+	case tc >= 5 && tc <= 8:
+		m.HasOnGround, m.OnGround = true, true
+		m.Position, m.HasPosition = Position(data)
+		m.GroundSpeed, m.HasVelocity = movement(bits(me, 6, 12))
+		if bits(me, 13, 13) == 1 {
+			m.Track = float64(bits(me, 14, 20)) * 360 / 128
+		} else {
+			m.HasVelocity = false
+		}
+
+	case tc >= 9 && tc <= 18:
+		m.HasOnGround, m.OnGround = true, false
+		m.Position, m.HasPosition = Position(data)
+		m.Altitude, m.HasAltitude = altitude12(bits(me, 9, 20))
+
+	case tc >= 20 && tc <= 22:
+		// GNSS height: not the barometric altitude reported elsewhere.
+		m.HasOnGround, m.OnGround = true, false
+		m.Position, m.HasPosition = Position(data)
+
+	case tc == 19:
+		m.decodeVelocity(me)
+	}
+}
+
+func (m *Message) decodeVelocity(me []byte) {
+	st := bits(me, 6, 8)
+
+	if vr := bits(me, 38, 46); vr != 0 {
+		m.HasVerticalRate = true
+		m.VerticalRate = int(vr-1) * 64
+		if bits(me, 37, 37) == 1 {
+			m.VerticalRate = -m.VerticalRate
+		}
+	}
+
+	if st != 1 && st != 2 {
+		// Subtypes 3 and 4 give airspeed and heading, not ground speed and track.
+		return
+	}
+
+	vew, vns := bits(me, 15, 24), bits(me, 26, 35)
+	if vew == 0 || vns == 0 {
+		return
+	}
+
+	scale := 1.0
+	if st == 2 {
+		// Supersonic.
+		scale = 4
+	}
+
+	ew := float64(vew-1) * scale
+	if bits(me, 14, 14) == 1 {
+		ew = -ew
+	}
+	ns := float64(vns-1) * scale
+	if bits(me, 25, 25) == 1 {
+		ns = -ns
+	}
+
+	m.HasVelocity = true
+	m.GroundSpeed = math.Hypot(ew, ns)
+	m.Track = math.Mod(math.Atan2(ew, ns)*180/math.Pi+360, 360)
+}
+
+// bits returns the bits from first to last inclusive, numbered from 1 at the
+// most significant bit of b[0].
+func bits(b []byte, first, last int) uint32 {
+	var v uint32
+	for i := first - 1; i < last; i++ {
+		v = v<<1 | uint32(b[i/8]>>(7-i%8))&1
+	}
+	return v
+}
+
+// ac13 returns the 13-bit altitude or identity code of DF0/4/5/16/20/21.
+func ac13(data []byte) uint32 {
+	return uint32(data[2]&0x1f)<<8 | uint32(data[3])
+}
+
+// flightStatus decodes the FS field of DF4/5/20/21.
+func flightStatus(data []byte) (onGround bool, ok bool) {
+	switch data[0] & 0x07 {
+	case 0, 2:
+		return false, true
+	case 1, 3:
+		return true, true
+	}
+	return false, false
+}
+
+// capability decodes the CA field of DF11/17.
+func capability(data []byte) (onGround bool, ok bool) {
+	switch data[0] & 0x07 {
+	case 4:
+		return true, true
+	case 5:
+		return false, true
+	}
+	return false, false
+}
+
+// movement decodes the ground speed of a surface position message.
+func movement(mov uint32) (float64, bool) {
+	m := float64(mov)
+	switch {
+	case mov == 1:
+		return 0, true
+	case mov >= 2 && mov <= 8:
+		return (m - 1) * 0.125, true
+	case mov >= 9 && mov <= 12:
+		return 1 + (m-8)*0.25, true
+	case mov >= 13 && mov <= 38:
+		return 2 + (m-12)*0.5, true
+	case mov >= 39 && mov <= 93:
+		return 15 + (m - 38), true
+	case mov >= 94 && mov <= 108:
+		return 70 + (m-93)*2, true
+	case mov >= 109 && mov <= 123:
+		return 100 + (m-108)*5, true
+	case mov == 124:
+		return 175, true
+	}
+	return 0, false
+}
+
+// altitude13 decodes a 13-bit AC field, in feet.
+func altitude13(ac uint32) (int, bool) {
+	if ac == 0 || ac&0x40 != 0 {
+		// Absent, or in metres, which nobody uses.
+		return 0, false
+	}
+
+	if ac&0x10 != 0 {
+		n := (ac&0x1f80)>>2 | (ac&0x20)>>1 | ac&0x0f
+		return int(n)*25 - 1000, true
+	}
+
+	return gillham(ac)
+}
+
+// altitude12 decodes the 12-bit altitude of an airborne position message.
+func altitude12(ac uint32) (int, bool) {
+	if ac == 0 {
+		return 0, false
+	}
+
+	// Insert a zero M bit to make it a 13-bit field.
+	return altitude13((ac&0xfc0)<<1 | ac&0x3f)
+}
+
+// gillhamOrder rearranges a 13-bit identity/altitude field into 0xABCD form,
+// where each nibble holds the three bits of one octal digit.
+func gillhamOrder(id uint32) uint32 {
+	var g uint32
+	for _, m := range [...]struct{ from, to uint32 }{
+		{0x1000, 0x0010}, // C1
+		{0x0800, 0x1000}, // A1
+		{0x0400, 0x0020}, // C2
+		{0x0200, 0x2000}, // A2
+		{0x0100, 0x0040}, // C4
+		{0x0080, 0x4000}, // A4
+		{0x0020, 0x0100}, // B1
+		{0x0010, 0x0001}, // D1
+		{0x0008, 0x0200}, // B2
+		{0x0004, 0x0002}, // D2
+		{0x0002, 0x0400}, // B4
+		{0x0001, 0x0004}, // D4
+	} {
+		if id&m.from != 0 {
+			g |= m.to
+		}
+	}
+	return g
+}
+
+// squawk decodes a 13-bit identity field into the 4-digit octal Mode A code.
+func squawk(id uint32) string {
+	return fmt.Sprintf("%04x", gillhamOrder(id))
+}
+
+// gillham decodes a Gillham (Mode C) coded altitude, in feet.
+func gillham(ac uint32) (int, bool) {
+	g := gillhamOrder(ac)
+	if g&0xffff8889 != 0 || g&0xf0 == 0 {
+		return 0, false
+	}
+
+	var fiveHundreds, oneHundreds uint32
+	if g&0x0010 != 0 {
+		oneHundreds ^= 0x007
+	}
+	if g&0x0020 != 0 {
+		oneHundreds ^= 0x003
+	}
+	if g&0x0040 != 0 {
+		oneHundreds ^= 0x001
+	}
+	if oneHundreds&5 == 5 {
+		oneHundreds ^= 2
+	}
+	if oneHundreds > 5 {
+		return 0, false
+	}
+
+	for _, m := range [...]struct{ bit, xor uint32 }{
+		{0x0002, 0x0ff}, // D2
+		{0x0004, 0x07f}, // D4
+		{0x1000, 0x03f}, // A1
+		{0x2000, 0x01f}, // A2
+		{0x4000, 0x00f}, // A4
+		{0x0100, 0x007}, // B1
+		{0x0200, 0x003}, // B2
+		{0x0400, 0x001}, // B4
+	} {
+		if g&m.bit != 0 {
+			fiveHundreds ^= m.xor
+		}
+	}
+
+	if fiveHundreds&1 != 0 {
+		oneHundreds = 6 - oneHundreds
+	}
+
+	n := int(fiveHundreds*5+oneHundreds) - 13
+	if n < -12 {
+		return 0, false
+	}
+
+	return n * 100, true
+}
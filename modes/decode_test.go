@@ -0,0 +1,76 @@
+package modes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeIdentification(t *testing.T) {
+	m, ok := Decode(mustDecode(t, "8d4840d6202cc371c32ce0576098"))
+	assert.True(t, ok)
+	assert.Equal(t, 17, m.DF)
+	assert.Equal(t, uint32(0x4840d6), m.ICAO)
+	assert.Equal(t, 4, m.TypeCode)
+	assert.Equal(t, "KLM1023", m.Callsign)
+}
+
+func TestDecodeAirbornePosition(t *testing.T) {
+	m, ok := Decode(mustDecode(t, evenPosition))
+	assert.True(t, ok)
+	assert.True(t, m.HasAltitude)
+	assert.Equal(t, 38000, m.Altitude)
+	assert.True(t, m.HasPosition)
+	assert.False(t, m.Position.Odd)
+	assert.True(t, m.HasOnGround)
+	assert.False(t, m.OnGround)
+}
+
+func TestDecodeVelocity(t *testing.T) {
+	m, ok := Decode(mustDecode(t, "8d485020994409940838175b284f"))
+	assert.True(t, ok)
+	assert.True(t, m.HasVelocity)
+	assert.InDelta(t, 159.20, m.GroundSpeed, 0.01)
+	assert.InDelta(t, 182.88, m.Track, 0.01)
+	assert.True(t, m.HasVerticalRate)
+	assert.Equal(t, -832, m.VerticalRate)
+}
+
+func TestDecodeAltitudeReply(t *testing.T) {
+	m, ok := Decode(mustDecode(t, "20001838ca3804"))
+	assert.True(t, ok)
+	assert.Equal(t, 4, m.DF)
+	assert.True(t, m.HasAltitude)
+	assert.Equal(t, 38000, m.Altitude)
+}
+
+func TestDecodeIdentityReply(t *testing.T) {
+	m, ok := Decode(mustDecode(t, "2a00516d492b80"))
+	assert.True(t, ok)
+	assert.Equal(t, 5, m.DF)
+	assert.Equal(t, "0356", m.Squawk)
+}
+
+func TestGillham(t *testing.T) {
+	_, ok := gillham(0)
+	assert.False(t, ok)
+
+	// Every valid code should give a different altitude, together covering
+	// -1200ft to 126700ft in 100ft steps.
+	seen := make(map[int]bool)
+	for ac := uint32(0); ac < 1<<13; ac++ {
+		if ac&0x40 != 0 || ac&0x10 != 0 {
+			// M and Q bits.
+			continue
+		}
+		if alt, ok := gillham(ac); ok {
+			assert.False(t, seen[alt], "duplicate %d", alt)
+			seen[alt] = true
+		}
+	}
+
+	assert.Equal(t, 1280, len(seen))
+	for alt := -1200; alt <= 126700; alt += 100 {
+		assert.True(t, seen[alt], "missing %d", alt)
+	}
+}
@@ -0,0 +1,197 @@
+package modes
+
+import (
+	"math"
+	"strings"
+)
+
+// setBits sets the bits from first to last inclusive, numbered as for bits,
+// to the low bits of v.
+func setBits(b []byte, first, last int, v uint32) {
+	for i := last - 1; i >= first-1; i-- {
+		mask := byte(1) << (7 - i%8)
+		if v&1 != 0 {
+			b[i/8] |= mask
+		} else {
+			b[i/8] &^= mask
+		}
+		v >>= 1
+	}
+}
+
+// SetParity fills in the trailing 24-bit parity field of a message, overlaid
+// with ap (the ICAO address for Address/Parity formats, or zero).
+func SetParity(data []byte, ap uint32) {
+	n := len(data)
+	p := CRC(data[:n-3]) ^ ap
+	data[n-3], data[n-2], data[n-1] = byte(p>>16), byte(p>>8), byte(p)
+}
+
+// extendedSquitter returns a DF17 message from icao with the parity field
+// still to be set. ME is data[4:11].
+func extendedSquitter(icao uint32, onGround bool) []byte {
+	data := make([]byte, LongLength)
+	data[0] = 17<<3 | 5
+	if onGround {
+		data[0] = 17<<3 | 4
+	}
+	data[1], data[2], data[3] = byte(icao>>16), byte(icao>>8), byte(icao)
+	return data
+}
+
+// EncodeAllCall returns a DF11 all-call reply from icao, as sent in answer to
+// an interrogation with interrogator identifier zero.
+func EncodeAllCall(icao uint32, onGround bool) []byte {
+	data := make([]byte, ShortLength)
+	data[0] = 11<<3 | 5
+	if onGround {
+		data[0] = 11<<3 | 4
+	}
+	data[1], data[2], data[3] = byte(icao>>16), byte(icao>>8), byte(icao)
+	SetParity(data, 0)
+	return data
+}
+
+// EncodeIdentification returns a DF17 identification message. category is
+// in the form decoded by Decode (e.g. "A3"), or empty. Characters that can't
+// be encoded in a callsign are replaced by spaces.
+func EncodeIdentification(icao uint32, category, callsign string) []byte {
+	data := extendedSquitter(icao, false)
+	me := data[4:11]
+
+	tc, ca := uint32(4), uint32(0)
+	if len(category) == 2 && category[0] >= 'A' && category[0] <= 'D' && category[1] >= '0' && category[1] <= '7' {
+		tc = uint32(4 - (category[0] - 'A'))
+		ca = uint32(category[1] - '0')
+	}
+	setBits(me, 1, 5, tc)
+	setBits(me, 6, 8, ca)
+
+	callsign = strings.ToUpper(callsign)
+	for i := 0; i < 8; i++ {
+		c := uint32(' ')
+		if i < len(callsign) && callsign[i] != '#' {
+			if j := strings.IndexByte(callsignChars, callsign[i]); j >= 0 {
+				c = uint32(j)
+			}
+		}
+		setBits(me, 9+6*i, 14+6*i, c)
+	}
+
+	SetParity(data, 0)
+	return data
+}
+
+// EncodeCPR returns the airborne compact position report for a position.
+func EncodeCPR(lat, lon float64, odd bool) CPR {
+	return encodeCPR(lat, lon, odd, 360)
+}
+
+// EncodeSurfaceCPR returns the surface compact position report for a
+// position.
+func EncodeSurfaceCPR(lat, lon float64, odd bool) CPR {
+	c := encodeCPR(lat, lon, odd, 90)
+	c.Surface = true
+	return c
+}
+
+// encodeCPR encodes a position in zones spanning 360 degrees for airborne
+// reports, or 90 for surface ones.
+func encodeCPR(lat, lon float64, odd bool, span float64) CPR {
+	i := 0.0
+	if odd {
+		i = 1
+	}
+
+	dLat := span / (60 - i)
+	yz := math.Floor(cprMax*mod(lat, dLat)/dLat + 0.5)
+	rLat := dLat * (yz/cprMax + math.Floor(lat/dLat))
+
+	dLon := span
+	if nl := float64(NL(rLat)) - i; nl > 0 {
+		dLon = span / nl
+	}
+	xz := math.Floor(cprMax*mod(lon, dLon)/dLon + 0.5)
+
+	return CPR{
+		Odd: odd,
+		Lat: uint32(yz) & (cprMax - 1),
+		Lon: uint32(xz) & (cprMax - 1),
+	}
+}
+
+// EncodeAirbornePosition returns a DF17 airborne position message with
+// barometric altitude. Altitudes outside the range that can be encoded in
+// 25ft steps are sent as unknown.
+func EncodeAirbornePosition(icao uint32, altitude int, c CPR) []byte {
+	data := extendedSquitter(icao, false)
+	me := data[4:11]
+
+	setBits(me, 1, 5, 11)
+
+	if n := (altitude + 1000) / 25; altitude >= -1000 && n <= 0x7ff {
+		ac := uint32(n)
+		setBits(me, 9, 20, (ac&0x7e0)<<1|(ac&0x10)<<1|0x10|ac&0x0f)
+	}
+
+	if c.Odd {
+		setBits(me, 22, 22, 1)
+	}
+	setBits(me, 23, 39, c.Lat)
+	setBits(me, 40, 56, c.Lon)
+
+	SetParity(data, 0)
+	return data
+}
+
+// EncodeSurfacePosition returns a DF17 surface position message, without
+// movement or track.
+func EncodeSurfacePosition(icao uint32, c CPR) []byte {
+	data := extendedSquitter(icao, true)
+	me := data[4:11]
+
+	setBits(me, 1, 5, 7)
+
+	if c.Odd {
+		setBits(me, 22, 22, 1)
+	}
+	setBits(me, 23, 39, c.Lat)
+	setBits(me, 40, 56, c.Lon)
+
+	SetParity(data, 0)
+	return data
+}
+
+// EncodeVelocity returns a DF17 subsonic airborne velocity message, giving
+// ground speed in knots, track in degrees and barometric vertical rate in
+// feet per minute.
+func EncodeVelocity(icao uint32, groundSpeed, track float64, verticalRate int) []byte {
+	data := extendedSquitter(icao, false)
+	me := data[4:11]
+
+	setBits(me, 1, 5, 19)
+	setBits(me, 6, 8, 1)
+
+	component := func(sign, first int, v float64) {
+		if v < 0 {
+			setBits(me, sign, sign, 1)
+			v = -v
+		}
+		setBits(me, first, first+9, uint32(math.Min(math.Round(v)+1, 1023)))
+	}
+	rad := track * math.Pi / 180
+	component(14, 15, groundSpeed*math.Sin(rad))
+	component(25, 26, groundSpeed*math.Cos(rad))
+
+	// Barometric source.
+	setBits(me, 36, 36, 1)
+	vr := verticalRate
+	if vr < 0 {
+		setBits(me, 37, 37, 1)
+		vr = -vr
+	}
+	setBits(me, 38, 46, uint32(math.Min(math.Round(float64(vr)/64)+1, 511)))
+
+	SetParity(data, 0)
+	return data
+}
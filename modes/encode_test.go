@@ -0,0 +1,96 @@
+package modes
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeIdentification(t *testing.T) {
+	assert.Equal(t, "8d4840d6202cc371c32ce0576098", hex.EncodeToString(EncodeIdentification(0x4840d6, "", "KLM1023")))
+
+	data := EncodeIdentification(0xabcdef, "A3", "ezy12a!")
+	assert.True(t, CheckParity(data))
+	m, ok := Decode(data)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0xabcdef), m.ICAO)
+	assert.Equal(t, "EZY12A", m.Callsign)
+	assert.Equal(t, "A3", m.Category)
+}
+
+func TestEncodeAllCall(t *testing.T) {
+	for _, onGround := range []bool{false, true} {
+		data := EncodeAllCall(0x40621d, onGround)
+		assert.True(t, CheckParity(data))
+		m, ok := Decode(data)
+		assert.True(t, ok)
+		assert.Equal(t, 11, m.DF)
+		assert.Equal(t, uint32(0x40621d), m.ICAO)
+		assert.True(t, m.HasOnGround)
+		assert.Equal(t, onGround, m.OnGround)
+	}
+}
+
+func TestEncodeAirbornePosition(t *testing.T) {
+	for _, p := range []struct{ lat, lon float64 }{
+		{52.2572, 3.91937},
+		{-33.9461, 151.1772},
+		{40.6413, -73.7781},
+		{-0.001, -179.999},
+		{86.5, 10},
+	} {
+		even := EncodeAirbornePosition(0x40621d, 38000, EncodeCPR(p.lat, p.lon, false))
+		odd := EncodeAirbornePosition(0x40621d, 38000, EncodeCPR(p.lat, p.lon, true))
+		assert.True(t, CheckParity(even))
+		assert.True(t, CheckParity(odd))
+
+		me, _ := Decode(even)
+		mo, _ := Decode(odd)
+		assert.True(t, me.HasPosition)
+		assert.Equal(t, 38000, me.Altitude)
+
+		lat, lon, ok := DecodeGlobal(me.Position, mo.Position, true)
+		assert.True(t, ok, "%v", p)
+		assert.InDelta(t, p.lat, lat, 0.001, "%v", p)
+		assert.InDelta(t, p.lon, lon, 0.001, "%v", p)
+
+		lat, lon = DecodeLocal(me.Position, p.lat+0.5, p.lon-0.5)
+		assert.InDelta(t, p.lat, lat, 0.001, "%v", p)
+		assert.InDelta(t, p.lon, lon, 0.001, "%v", p)
+	}
+}
+
+func TestEncodeAltitude(t *testing.T) {
+	for alt := -1000; alt <= 50175; alt += 25 {
+		m, _ := Decode(EncodeAirbornePosition(0x40621d, alt, CPR{}))
+		if !assert.True(t, m.HasAltitude, alt) {
+			return
+		}
+		assert.Equal(t, alt, m.Altitude)
+	}
+
+	m, _ := Decode(EncodeAirbornePosition(0x40621d, 60000, CPR{}))
+	assert.False(t, m.HasAltitude)
+}
+
+func TestEncodeVelocity(t *testing.T) {
+	for _, v := range []struct {
+		speed, track float64
+		rate         int
+	}{
+		{159, 182.88, -832},
+		{450, 45, 0},
+		{300, 300, 2048},
+	} {
+		data := EncodeVelocity(0x485020, v.speed, v.track, v.rate)
+		assert.True(t, CheckParity(data))
+		m, ok := Decode(data)
+		assert.True(t, ok)
+		assert.True(t, m.HasVelocity)
+		assert.InDelta(t, v.speed, m.GroundSpeed, 1)
+		assert.InDelta(t, v.track, m.Track, 0.5)
+		assert.True(t, m.HasVerticalRate)
+		assert.Equal(t, v.rate, m.VerticalRate)
+	}
+}
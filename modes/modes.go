@@ -0,0 +1,114 @@
+// Package modes decodes Mode S downlink messages, as carried in the payload of
+// Mode-S short and long beast frames.
+//
+// See "The 1090MHz Riddle" (https://mode-s.org/decode/) for a description of
+// the formats.
+package modes
+
+const (
+	// ShortLength is the length in bytes of a 56-bit Mode S message.
+	ShortLength = 7
+	// LongLength is the length in bytes of a 112-bit Mode S message.
+	LongLength = 14
+
+	// generator is the Mode S CRC-24 polynomial.
+	generator = 0xfff409
+)
+
+var crcTable [256]uint32
+
+func init() {
+	for i := range crcTable {
+		c := uint32(i) << 16
+		for j := 0; j < 8; j++ {
+			if c&0x800000 != 0 {
+				c = (c << 1) ^ generator
+			} else {
+				c <<= 1
+			}
+		}
+		crcTable[i] = c & 0xffffff
+	}
+}
+
+// CRC returns the Mode S CRC-24 of data.
+func CRC(data []byte) uint32 {
+	var c uint32
+	for _, b := range data {
+		c = ((c << 8) ^ crcTable[byte(c>>16)^b]) & 0xffffff
+	}
+	return c
+}
+
+// Parity returns the result of comparing the computed CRC of the message with
+// its trailing 24-bit parity field. For messages with plain parity (DF11 with
+// IID zero, DF17, DF18) it is zero for an undamaged message; for messages using
+// Address/Parity it is the ICAO address of the transponder.
+func Parity(data []byte) uint32 {
+	n := len(data)
+	if n < 4 {
+		return 0
+	}
+	ap := uint32(data[n-3])<<16 | uint32(data[n-2])<<8 | uint32(data[n-1])
+	return CRC(data[:n-3]) ^ ap
+}
+
+// CheckParity reports whether a message whose parity can be checked directly
+// (DF11, DF17, DF18) is undamaged. It returns false for all other formats.
+func CheckParity(data []byte) bool {
+	df := DownlinkFormat(data)
+	if df < 0 || len(data) != ExpectedLength(df) {
+		return false
+	}
+
+	switch df {
+	case 11:
+		// The low 7 bits may hold the interrogator identifier.
+		return Parity(data)&^0x7f == 0
+	case 17, 18:
+		return Parity(data) == 0
+	}
+
+	return false
+}
+
+// DownlinkFormat returns the DF of the message. All formats from 24 upwards
+// are reported as 24, since only the first two bits are significant.
+func DownlinkFormat(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	df := int(data[0] >> 3)
+	if df > 24 {
+		df = 24
+	}
+	return df
+}
+
+// ExpectedLength returns the length in bytes of a message with the given DF.
+func ExpectedLength(df int) int {
+	if df >= 16 {
+		return LongLength
+	}
+	return ShortLength
+}
+
+// ICAO returns the 24-bit address of the aircraft that transmitted the
+// message. For formats that overlay the address on the parity field the result
+// cannot be verified, so a damaged message will give a bogus address. The
+// second result is false if the message does not carry an address at all.
+func ICAO(data []byte) (uint32, bool) {
+	df := DownlinkFormat(data)
+	if df < 0 || len(data) != ExpectedLength(df) {
+		return 0, false
+	}
+
+	switch df {
+	case 11, 17, 18:
+		return uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]), true
+	case 0, 4, 5, 16, 20, 21:
+		return Parity(data), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package modes
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC(t *testing.T) {
+	// DF17 identification message, with a valid CRC.
+	b := mustDecode(t, "8d4840d6202cc371c32ce0576098")
+	assert.Equal(t, uint32(0), CRC(b))
+	assert.Equal(t, uint32(0), Parity(b))
+
+	b[5] ^= 1
+	assert.NotEqual(t, uint32(0), Parity(b))
+}
+
+func TestDownlinkFormat(t *testing.T) {
+	assert.Equal(t, 17, DownlinkFormat(mustDecode(t, "8d4840d6202cc371c32ce0576098")))
+	assert.Equal(t, 11, DownlinkFormat(mustDecode(t, "5d4840d6ff7640")))
+	assert.Equal(t, 24, DownlinkFormat([]byte{0xff}))
+	assert.Equal(t, -1, DownlinkFormat(nil))
+}
+
+func TestICAO(t *testing.T) {
+	icao, ok := ICAO(mustDecode(t, "8d4840d6202cc371c32ce0576098"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x4840d6), icao)
+
+	// DF4 altitude reply: address is overlaid on the parity.
+	b := mustDecode(t, "20001838000000")
+	ap := CRC(b[:4]) ^ 0x3c6dd6
+	b[4], b[5], b[6] = byte(ap>>16), byte(ap>>8), byte(ap)
+	icao, ok = ICAO(b)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0x3c6dd6), icao)
+
+	// Wrong length for the DF.
+	_, ok = ICAO(mustDecode(t, "8d4840d6202cc3"))
+	assert.False(t, ok)
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCheckParity(t *testing.T) {
+	assert.True(t, CheckParity(mustDecode(t, "8d4840d6202cc371c32ce0576098")))
+	assert.False(t, CheckParity(mustDecode(t, "8d4840d6202cc371c32ce0576099")))
+	assert.False(t, CheckParity(mustDecode(t, "20001838ca3804")))
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"dump1090-proxy/spec"
+)
+
+// Reasons for rejecting a connection, used as metric labels.
+const (
+	rejectAddress    = "address"
+	rejectToken      = "token"
+	rejectMaxClients = "max-clients"
+	rejectHandshake  = "handshake"
+)
+
+// access controls which clients may connect to a listener. The zero value
+// admits everyone.
+type access struct {
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	token      string
+	maxClients int
+}
+
+func parseAccess(s *spec.Spec) (access, error) {
+	a := access{
+		token:      s.String("token", ""),
+		maxClients: s.Int("max-clients", 0),
+	}
+
+	var err error
+	if a.allow, err = parseCIDRs(s.List("allow-cidr")); err != nil {
+		return a, err
+	}
+	if a.deny, err = parseCIDRs(s.List("deny-cidr")); err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+func parseCIDRs(l []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range l {
+		if !strings.Contains(v, "/") {
+			// A single address.
+			if strings.Contains(v, ":") {
+				v += "/128"
+			} else {
+				v += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// permitted reports whether a client may connect from addr.
+func (a *access) permitted(addr net.Addr) bool {
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range a.deny {
+		if n.Contains(tcpAddr.IP) {
+			return false
+		}
+	}
+
+	if len(a.allow) == 0 {
+		return true
+	}
+
+	for _, n := range a.allow {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkToken reads the line the client must send before it receives any
+// data, and compares it to the expected token. No more is read than the
+// longest line that could hold the token, so that clients can't make us
+// buffer an endless line.
+func (a *access) checkToken(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	limit := int64(len(a.token) + len("\r\n"))
+	line, err := bufio.NewReader(io.LimitReader(conn, limit)).ReadString('\n')
+	if err == io.EOF {
+		return fmt.Errorf("token too long, or not followed by a newline")
+	}
+	if err != nil {
+		return err
+	}
+
+	got := strings.TrimRight(line, "\r\n")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+		return fmt.Errorf("incorrect token")
+	}
+
+	return nil
+}
+
+// sendToken identifies us to a remote that requires a token.
+func sendToken(conn net.Conn, token string) error {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetWriteDeadline(time.Time{})
+
+	_, err := conn.Write([]byte(token + "\n"))
+	return err
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sendLine writes a client's handshake, returning how much of it was read.
+func sendLine(t *testing.T, a *access, line string) (int, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	written := make(chan int, 1)
+	go func() {
+		n, _ := client.Write([]byte(line))
+		written <- n
+	}()
+
+	err := a.checkToken(server)
+	server.Close()
+	return <-written, err
+}
+
+func TestCheckToken(t *testing.T) {
+	a := &access{token: "SECRET"}
+
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+	}{
+		{name: "token", line: "SECRET\n"},
+		{name: "crlf", line: "SECRET\r\n"},
+		{name: "wrong", line: "PASSWORD\n", wantErr: "too long"},
+		{name: "short", line: "SECRE\n", wantErr: "incorrect token"},
+		{name: "prefix", line: "SECRETS\n", wantErr: "incorrect token"},
+		{name: "empty", line: "\n", wantErr: "incorrect token"},
+		{name: "no newline", line: "SECRET  ", wantErr: "too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sendLine(t, a, tt.line)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckTokenLongLine(t *testing.T) {
+	a := &access{token: "SECRET"}
+
+	// The handshake is abandoned without reading the rest of the line.
+	n, err := sendLine(t, a, strings.Repeat("x", 1<<20)+"\n")
+	assert.ErrorContains(t, err, "too long")
+	assert.Equal(t, len("SECRET\r\n"), n)
+}
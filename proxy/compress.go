@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+
+	"dump1090-proxy/spec"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Compression methods for links between proxies.
+const (
+	compressNone    = ""
+	compressGzip    = "gzip"
+	compressDeflate = "deflate"
+)
+
+func parseCompression(s *spec.Spec) (string, error) {
+	switch c := s.String("compress", compressNone); c {
+	case compressNone, compressGzip, compressDeflate:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown compression method %q", c)
+	}
+}
+
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// countingWriter counts the bytes written to a link.
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.counter.Add(float64(n))
+	return n, err
+}
+
+// countingReader counts the bytes read from a link.
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.counter.Add(float64(n))
+	return n, err
+}
+
+// compressedConn compresses everything written to a client connection. Data
+// is only guaranteed to be sent once Flush is called.
+type compressedConn struct {
+	net.Conn
+	w            flushWriter
+	uncompressed prometheus.Counter
+}
+
+// newCompressedConn wraps a client connection, counting bytes in the
+// compression_bytes metric.
+func newCompressedConn(conn net.Conn, method string, link string, compressionBytes *prometheus.CounterVec) net.Conn {
+	wire := countingWriter{
+		w:       conn,
+		counter: compressionBytes.With(prometheus.Labels{"link": link, "direction": "sent", "stage": "compressed"}),
+	}
+
+	c := &compressedConn{
+		Conn:         conn,
+		uncompressed: compressionBytes.With(prometheus.Labels{"link": link, "direction": "sent", "stage": "uncompressed"}),
+	}
+
+	switch method {
+	case compressGzip:
+		c.w = gzip.NewWriter(wire)
+	case compressDeflate:
+		// Can only fail for an invalid level.
+		c.w, _ = flate.NewWriter(wire, flate.DefaultCompression)
+	default:
+		panic("unknown compression method " + method)
+	}
+
+	return c
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.uncompressed.Add(float64(n))
+	return n, err
+}
+
+func (c *compressedConn) Flush() error {
+	return c.w.Flush()
+}
+
+// newDecompressingReader returns a reader of the uncompressed stream from a
+// remote.
+func newDecompressingReader(r io.Reader, method string, link string, compressionBytes *prometheus.CounterVec) (io.Reader, error) {
+	wire := countingReader{
+		r:       r,
+		counter: compressionBytes.With(prometheus.Labels{"link": link, "direction": "received", "stage": "compressed"}),
+	}
+
+	var dr io.Reader
+	switch method {
+	case compressGzip:
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, err
+		}
+		dr = zr
+	case compressDeflate:
+		dr = flate.NewReader(wire)
+	default:
+		panic("unknown compression method " + method)
+	}
+
+	return countingReader{
+		r:       dr,
+		counter: compressionBytes.With(prometheus.Labels{"link": link, "direction": "received", "stage": "uncompressed"}),
+	}, nil
+}
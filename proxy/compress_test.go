@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/spec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufferConn is a client connection that saves what is written to it.
+type bufferConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func newCompressionBytes() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "compression_bytes"}, []string{"link", "direction", "stage"})
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	var frames []byte
+	for i := 0; i < 100; i++ {
+		frames = append(frames, identification(0x400000+uint32(i%10), "TEST")...)
+	}
+
+	for _, method := range []string{compressGzip, compressDeflate} {
+		t.Run(method, func(t *testing.T) {
+			counters := newCompressionBytes()
+			conn := &bufferConn{}
+			c := newCompressedConn(conn, method, "link", counters)
+
+			n, err := c.Write(frames)
+			require.NoError(t, err)
+			assert.Equal(t, len(frames), n)
+			require.NoError(t, c.(flushWriter).Flush())
+
+			sent := conn.buf.Len()
+			assert.Less(t, sent, len(frames), "repetitive frames should compress")
+			assert.Equal(t, float64(len(frames)), testutil.ToFloat64(counters.WithLabelValues("link", "sent", "uncompressed")))
+			assert.Equal(t, float64(sent), testutil.ToFloat64(counters.WithLabelValues("link", "sent", "compressed")))
+
+			// Everything flushed can be read back, frame by frame, without
+			// waiting for the stream to end.
+			r, err := newDecompressingReader(&conn.buf, method, "link", counters)
+			require.NoError(t, err)
+			br := bufio.NewReader(r)
+			var got []byte
+			for len(got) < len(frames) {
+				msg, err := beast.ReadMessage(br)
+				require.NoError(t, err)
+				got = append(got, msg...)
+			}
+			assert.Equal(t, frames, got)
+			assert.Equal(t, float64(sent), testutil.ToFloat64(counters.WithLabelValues("link", "received", "compressed")))
+			assert.Equal(t, float64(len(frames)), testutil.ToFloat64(counters.WithLabelValues("link", "received", "uncompressed")))
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, tt := range []struct {
+		options string
+		want    string
+		wantErr string
+	}{
+		{options: "", want: compressNone},
+		{options: "compress=gzip", want: compressGzip},
+		{options: "compress=deflate", want: compressDeflate},
+		{options: "compress=zstd", wantErr: `unknown compression method "zstd"`},
+		{options: "compress=GZIP", wantErr: `unknown compression method "GZIP"`},
+	} {
+		t.Run(tt.options, func(t *testing.T) {
+			s, err := spec.Parse("127.0.0.1:0?" + tt.options)
+			require.NoError(t, err)
+			got, err := parseCompression(s)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompressedLink(t *testing.T) {
+	for _, method := range []string{compressGzip, compressDeflate} {
+		t.Run(method, func(t *testing.T) {
+			// A receiver feeds an upstream proxy, which passes its frames
+			// compressed to a downstream proxy.
+			r := newFakeRemote(t)
+			upstream := startProxy(t, Options{
+				Remotes:       []string{r.addr()},
+				Listeners:     []string{"127.0.0.1:0?compress=" + method},
+				FlushInterval: 10 * time.Millisecond,
+			})
+			rc := r.next(t)
+
+			downstream := startProxy(t, Options{
+				Remotes: []string{upstream.ListenAddrs()[0].String() + "?compress=" + method},
+			})
+			require.Eventually(t, func() bool {
+				return testutil.ToFloat64(upstream.metrics.inboundConnections) == 1
+			}, waitFor, 10*time.Millisecond)
+			client := downstream.dial(t, 0)
+
+			f1, f2 := identification(0x400001, "ONE"), identification(0x400002, "TWO")
+			_, err := rc.Write(append(append([]byte(nil), f1...), f2...))
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{string(f1), string(f2)}, readFrames(t, client, 2))
+
+			link := upstream.ListenAddrs()[0].String()
+			assert.Greater(t, testutil.ToFloat64(downstream.metrics.compressionBytes.WithLabelValues(link, "received", "compressed")), 0.0)
+			assert.Equal(t, float64(len(f1)+len(f2)), testutil.ToFloat64(downstream.metrics.compressionBytes.WithLabelValues(link, "received", "uncompressed")))
+		})
+	}
+}
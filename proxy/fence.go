@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"dump1090-proxy/spec"
+)
+
+const earthRadiusKm = 6371.0
+
+// region is an area on the earth's surface.
+type region interface {
+	contains(lat, lon float64) bool
+}
+
+type point struct {
+	lat, lon float64
+}
+
+// polygon is a region bounded by straight lines (on a lat/lon projection,
+// which is adequate for regional areas not crossing the antimeridian).
+type polygon []point
+
+func (p polygon) contains(lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.lat > lat) != (b.lat > lat) &&
+			lon < (b.lon-a.lon)*(lat-a.lat)/(b.lat-a.lat)+a.lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+type circle struct {
+	centre   point
+	radiusKm float64
+}
+
+func (c circle) contains(lat, lon float64) bool {
+	return distanceKm(c.centre, point{lat: lat, lon: lon}) <= c.radiusKm
+}
+
+// distanceKm returns the great-circle distance between two points.
+func distanceKm(a, b point) float64 {
+	const rad = math.Pi / 180
+	dLat := (b.lat - a.lat) * rad
+	dLon := (b.lon - a.lon) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.lat*rad)*math.Cos(b.lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// fence restricts forwarding to aircraft inside at least one of the include
+// regions (if there are any), and outside all of the exclude regions.
+type fence struct {
+	include []region
+	exclude []region
+	// unknown says whether to forward aircraft whose position is not known.
+	unknown bool
+}
+
+// parseFence returns nil if the spec has no fence options.
+func parseFence(s *spec.Spec) (*fence, error) {
+	f := &fence{
+		unknown: s.Bool("fence-unknown", false),
+	}
+
+	var err error
+	if f.include, err = parseRegions(s, "fence"); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseRegions(s, "exclude"); err != nil {
+		return nil, err
+	}
+
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// parseRegions reads the prefix-polygon and prefix-circle options. A polygon
+// is "lat:lon,lat:lon,..." and a circle is "lat:lon:radiusKm".
+func parseRegions(s *spec.Spec, prefix string) ([]region, error) {
+	var regions []region
+
+	for _, v := range s.Strings(prefix + "-polygon") {
+		var p polygon
+		for _, vertex := range strings.Split(v, ",") {
+			pt, rest, err := parsePoint(vertex)
+			if err != nil || len(rest) != 0 {
+				return nil, fmt.Errorf("invalid polygon vertex %q", vertex)
+			}
+			p = append(p, pt)
+		}
+		if len(p) < 3 {
+			return nil, fmt.Errorf("polygon %q needs at least 3 vertices", v)
+		}
+		regions = append(regions, p)
+	}
+
+	for _, v := range s.Strings(prefix + "-circle") {
+		pt, rest, err := parsePoint(v)
+		if err != nil || len(rest) != 1 {
+			return nil, fmt.Errorf("invalid circle %q", v)
+		}
+		radius, err := strconv.ParseFloat(rest[0], 64)
+		if err != nil || radius <= 0 {
+			return nil, fmt.Errorf("invalid circle radius %q", v)
+		}
+		regions = append(regions, circle{centre: pt, radiusKm: radius})
+	}
+
+	return regions, nil
+}
+
+// parsePoint parses "lat:lon[:more...]", returning any remaining fields.
+func parsePoint(s string) (point, []string, error) {
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	if len(fields) < 2 {
+		return point{}, nil, fmt.Errorf("invalid point %q", s)
+	}
+
+	lat, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return point{}, nil, fmt.Errorf("invalid latitude %q", fields[0])
+	}
+	lon, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return point{}, nil, fmt.Errorf("invalid longitude %q", fields[1])
+	}
+
+	return point{lat: lat, lon: lon}, fields[2:], nil
+}
+
+func (f *fence) accept(lat, lon float64, known bool) bool {
+	if !known {
+		return f.unknown
+	}
+
+	for _, r := range f.exclude {
+		if r.contains(lat, lon) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, r := range f.include {
+		if r.contains(lat, lon) {
+			return true
+		}
+	}
+
+	return false
+}
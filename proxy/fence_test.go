@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"dump1090-proxy/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// locate gives the tracker a position for an aircraft.
+func locate(tracker *aircraft.Tracker, icao uint32, lat, lon float64) {
+	now := time.Now()
+	for _, odd := range []bool{false, true} {
+		data := modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(lat, lon, odd))
+		tracker.Update("r1", beast.Frame{Type: beast.ModeSLong, Signal: 0x80, Data: data}, now)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	london, paris := point{lat: 51.5074, lon: -0.1278}, point{lat: 48.8566, lon: 2.3522}
+	assert.InDelta(t, 343.5, distanceKm(london, paris), 1)
+	assert.InDelta(t, 343.5, distanceKm(paris, london), 1)
+	assert.Equal(t, 0.0, distanceKm(london, london))
+}
+
+func TestRegions(t *testing.T) {
+	// Roughly the North Sea between England and the Netherlands.
+	sea := polygon{{51, 1.5}, {54, 1.5}, {54, 4.5}, {51, 4.5}}
+	// A concave L shape.
+	l := polygon{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+	london := circle{centre: point{lat: 51.5074, lon: -0.1278}, radiusKm: 50}
+
+	tests := []struct {
+		name     string
+		r        region
+		lat, lon float64
+		want     bool
+	}{
+		{name: "in polygon", r: sea, lat: 52.2572, lon: 3.91937, want: true},
+		{name: "west of polygon", r: sea, lat: 52.2572, lon: 0, want: false},
+		{name: "north of polygon", r: sea, lat: 55, lon: 3, want: false},
+		{name: "in concave polygon", r: l, lat: 0.5, lon: 1.5, want: true},
+		{name: "in concave polygon's notch", r: l, lat: 1.5, lon: 1.5, want: false},
+		{name: "in circle", r: london, lat: 51.47, lon: -0.4543, want: true},
+		{name: "outside circle", r: london, lat: 52.2, lon: 0.1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.r.contains(tt.lat, tt.lon))
+		})
+	}
+}
+
+func TestParseFence(t *testing.T) {
+	s, err := spec.Parse("127.0.0.1:0?fence-polygon=51:1.5,54:1.5,54:4.5&fence-circle=51.5:-0.1:50&exclude-circle=52:2:10&fence-unknown=true")
+	require.NoError(t, err)
+	f, err := parseFence(s)
+	require.NoError(t, err)
+	require.NoError(t, s.Check())
+	assert.Equal(t, &fence{
+		include: []region{
+			polygon{{51, 1.5}, {54, 1.5}, {54, 4.5}},
+			circle{centre: point{lat: 51.5, lon: -0.1}, radiusKm: 50},
+		},
+		exclude: []region{circle{centre: point{lat: 52, lon: 2}, radiusKm: 10}},
+		unknown: true,
+	}, f)
+
+	// Without regions there is no fence, even if other fence options are set.
+	s, err = spec.Parse("127.0.0.1:0?fence-unknown=true")
+	require.NoError(t, err)
+	f, err = parseFence(s)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+}
+
+func TestParseFenceErrors(t *testing.T) {
+	tests := []struct {
+		options string
+		wantErr string
+	}{
+		{options: "fence-polygon=51:1,54:1", wantErr: "needs at least 3 vertices"},
+		{options: "fence-polygon=51:1,54:1,54", wantErr: `invalid polygon vertex "54"`},
+		{options: "fence-polygon=51:1,54:1,54:4:1", wantErr: `invalid polygon vertex "54:4:1"`},
+		{options: "exclude-polygon=91:1,54:1,54:4", wantErr: `invalid polygon vertex "91:1"`},
+		{options: "fence-circle=51:181:10", wantErr: `invalid circle "51:181:10"`},
+		{options: "fence-circle=51:1", wantErr: `invalid circle "51:1"`},
+		{options: "fence-circle=51:1:10:1", wantErr: `invalid circle "51:1:10:1"`},
+		{options: "exclude-circle=51:1:0", wantErr: `invalid circle radius "51:1:0"`},
+		{options: "fence-circle=51:1:far", wantErr: `invalid circle radius "51:1:far"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			s, err := spec.Parse("127.0.0.1:0?" + tt.options)
+			require.NoError(t, err)
+			_, err = parseFence(s)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestFenceFilter(t *testing.T) {
+	const (
+		inside   = 0x400001
+		excluded = 0x400002
+		outside  = 0x400003
+		unknown  = 0x400004
+	)
+	tracker := aircraft.NewTracker(time.Minute)
+	locate(tracker, inside, 52.2572, 3.91937)
+	locate(tracker, excluded, 52.5, 2.5)
+	locate(tracker, outside, 48.8566, 2.3522)
+	tracker.Update("r1", mustParseFrame(t, identification(unknown, "UNKNOWN")), time.Now())
+
+	modeAC := beast.Frame{Type: beast.ModeAC, Signal: 0x80, Data: []byte{0x12, 0x34}}
+	sea := "fence-polygon=51:1.5,54:1.5,54:4.5,51:4.5&exclude-circle=52.5:2.5:20"
+
+	tests := []struct {
+		name       string
+		options    string
+		frame      beast.Frame
+		wantReason string
+	}{
+		{name: "inside", options: sea, frame: mustParseFrame(t, identification(inside, "IN"))},
+		{name: "excluded", options: sea, frame: mustParseFrame(t, identification(excluded, "EX")), wantReason: reasonFence},
+		{name: "outside", options: sea, frame: mustParseFrame(t, identification(outside, "OUT")), wantReason: reasonFence},
+		{name: "unknown", options: sea, frame: mustParseFrame(t, identification(unknown, "UNKNOWN")), wantReason: reasonFence},
+		{name: "unknown allowed", options: sea + "&fence-unknown=true", frame: mustParseFrame(t, identification(unknown, "UNKNOWN"))},
+		{name: "mode ac", options: sea + "&fence-unknown=true", frame: modeAC, wantReason: reasonModeAC},
+
+		// With only exclusions, everywhere else is included.
+		{name: "only excluded", options: "exclude-circle=52.5:2.5:20", frame: mustParseFrame(t, identification(excluded, "EX")), wantReason: reasonFence},
+		{name: "only exclusions", options: "exclude-circle=52.5:2.5:20", frame: mustParseFrame(t, identification(outside, "OUT"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseTestFilter(tt.options)
+			require.NoError(t, err)
+
+			ok, reason := f.accept(tt.frame, tracker)
+			assert.Equal(t, tt.wantReason == "", ok)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
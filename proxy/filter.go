@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"dump1090-proxy/spec"
+)
+
+// filter decides which frames are forwarded to the clients of a listener.
+// Filters are made by parseFilter, which without options gives one that
+// accepts everything.
+type filter struct {
+	allowICAO map[uint32]bool
+	denyICAO  map[uint32]bool
+	allowDF   map[int]bool
+	noModeAC  bool
+	minSignal float64
+	fence     *fence
+}
+
+// Reasons for rejecting a frame, used as metric labels.
+const (
+	reasonICAO   = "icao"
+	reasonDF     = "df"
+	reasonModeAC = "modeac"
+	reasonSignal = "signal"
+	reasonFence  = "fence"
+)
+
+func parseFilter(s *spec.Spec) (filter, error) {
+	f := filter{
+		noModeAC:  !s.Bool("modeac", true),
+		minSignal: s.Float("min-signal", math.Inf(-1)),
+	}
+
+	var err error
+	if f.fence, err = parseFence(s); err != nil {
+		return f, err
+	}
+	if f.allowICAO, err = parseICAOs(s.List("allow-icao")); err != nil {
+		return f, err
+	}
+	if f.denyICAO, err = parseICAOs(s.List("deny-icao")); err != nil {
+		return f, err
+	}
+
+	for _, v := range s.List("df") {
+		df, err := strconv.Atoi(v)
+		if err != nil || df < 0 || df > 24 {
+			return f, fmt.Errorf("invalid downlink format %q", v)
+		}
+		if f.allowDF == nil {
+			f.allowDF = make(map[int]bool)
+		}
+		f.allowDF[df] = true
+	}
+
+	return f, nil
+}
+
+func parseICAOs(l []string) (map[uint32]bool, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	m := make(map[uint32]bool, len(l))
+	for _, v := range l {
+		icao, err := strconv.ParseUint(v, 16, 24)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICAO address %q", v)
+		}
+		m[uint32(icao)] = true
+	}
+
+	return m, nil
+}
+
+// accept reports whether the frame should be forwarded and, if not, why. The
+// tracker supplies aircraft positions for the fence.
+func (f *filter) accept(frame beast.Frame, tracker *aircraft.Tracker) (bool, string) {
+	if frame.RSSI() < f.minSignal {
+		return false, reasonSignal
+	}
+
+	if frame.Type == beast.ModeAC {
+		if f.noModeAC || f.allowDF != nil || f.allowICAO != nil || f.fence != nil {
+			// Mode A/C replies carry neither a DF nor an address, so cannot
+			// satisfy an allow list or be located.
+			return false, reasonModeAC
+		}
+		return true, ""
+	}
+
+	if f.allowDF != nil && !f.allowDF[modes.DownlinkFormat(frame.Data)] {
+		return false, reasonDF
+	}
+
+	if f.allowICAO != nil || f.denyICAO != nil || f.fence != nil {
+		icao, ok := modes.ICAO(frame.Data)
+		if f.allowICAO != nil && (!ok || !f.allowICAO[icao]) {
+			return false, reasonICAO
+		}
+		if ok && f.denyICAO[icao] {
+			return false, reasonICAO
+		}
+
+		if f.fence != nil {
+			var lat, lon float64
+			if ok {
+				lat, lon, ok = tracker.Position(icao)
+			}
+			if !f.fence.accept(lat, lon, ok) {
+				return false, reasonFence
+			}
+		}
+	}
+
+	return true, ""
+}
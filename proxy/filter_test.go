@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"dump1090-proxy/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseTestFilter parses the filter options of a listener.
+func parseTestFilter(options string) (filter, error) {
+	s, err := spec.Parse("127.0.0.1:0?" + options)
+	if err != nil {
+		return filter{}, err
+	}
+	f, err := parseFilter(s)
+	if err != nil {
+		return f, err
+	}
+	return f, s.Check()
+}
+
+func mustParseFrame(t *testing.T, b []byte) beast.Frame {
+	f, err := beast.ParseFrame(b)
+	require.NoError(t, err)
+	return f
+}
+
+func TestFilter(t *testing.T) {
+	one := mustParseFrame(t, identification(0x400001, "ONE"))
+	two := mustParseFrame(t, identification(0x400002, "TWO"))
+	allCall := beast.Frame{Type: beast.ModeSShort, Signal: 0x80, Data: modes.EncodeAllCall(0x400001, false)}
+	modeAC := beast.Frame{Type: beast.ModeAC, Signal: 0x80, Data: []byte{0x12, 0x34}}
+	weak := beast.Frame{Type: beast.ModeSLong, Signal: 0x20, Data: one.Data}
+	unmeasured := beast.Frame{Type: beast.ModeSLong, Data: one.Data}
+
+	tests := []struct {
+		name       string
+		options    string
+		frame      beast.Frame
+		wantReason string
+	}{
+		{name: "default", frame: one},
+		{name: "default mode ac", frame: modeAC},
+		{name: "default weak", frame: weak},
+
+		{name: "allowed", options: "allow-icao=400001,400003", frame: one},
+		{name: "not allowed", options: "allow-icao=400001,400003", frame: two, wantReason: reasonICAO},
+		{name: "allowed repeated", options: "allow-icao=400003&allow-icao=400002", frame: two},
+		{name: "allowed upper case", options: "allow-icao=4000FF,400001", frame: one},
+		{name: "allow list mode ac", options: "allow-icao=400001", frame: modeAC, wantReason: reasonModeAC},
+
+		{name: "denied", options: "deny-icao=400001", frame: one, wantReason: reasonICAO},
+		{name: "not denied", options: "deny-icao=400001", frame: two},
+		{name: "deny list mode ac", options: "deny-icao=400001", frame: modeAC},
+		{name: "denied all-call", options: "deny-icao=400001", frame: allCall, wantReason: reasonICAO},
+
+		{name: "df", options: "df=17", frame: one},
+		{name: "other df", options: "df=17", frame: allCall, wantReason: reasonDF},
+		{name: "df list", options: "df=11,17", frame: allCall},
+		{name: "df mode ac", options: "df=17", frame: modeAC, wantReason: reasonModeAC},
+
+		{name: "no mode ac", options: "modeac=false", frame: modeAC, wantReason: reasonModeAC},
+		{name: "no mode ac mode s", options: "modeac=false", frame: one},
+
+		// A signal of 0x80 is -6 dBFS, and 0x20 is -18 dBFS.
+		{name: "strong", options: "min-signal=-10", frame: one},
+		{name: "weak", options: "min-signal=-10", frame: weak, wantReason: reasonSignal},
+		{name: "unmeasured", options: "min-signal=-10", frame: unmeasured, wantReason: reasonSignal},
+		{name: "weak mode ac", options: "min-signal=-3", frame: modeAC, wantReason: reasonSignal},
+
+		{name: "combined", options: "allow-icao=400001&df=17&min-signal=-10", frame: one},
+		{name: "combined weak", options: "allow-icao=400001&df=17&min-signal=-10", frame: weak, wantReason: reasonSignal},
+	}
+
+	tracker := aircraft.NewTracker(time.Minute)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseTestFilter(tt.options)
+			require.NoError(t, err)
+
+			ok, reason := f.accept(tt.frame, tracker)
+			assert.Equal(t, tt.wantReason == "", ok)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		options string
+		wantErr string
+	}{
+		{options: "allow-icao=40000g", wantErr: `invalid ICAO address "40000g"`},
+		{options: "deny-icao=1000000", wantErr: `invalid ICAO address "1000000"`},
+		{options: "df=25", wantErr: `invalid downlink format "25"`},
+		{options: "df=-1", wantErr: `invalid downlink format "-1"`},
+		{options: "df=adsb", wantErr: `invalid downlink format "adsb"`},
+		{options: "modeac=sometimes", wantErr: `option "modeac"`},
+		{options: "min-signal=loud", wantErr: `option "min-signal"`},
+		{options: "allow-df=17", wantErr: "unknown option(s) allow-df"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			_, err := parseTestFilter(tt.options)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
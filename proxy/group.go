@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// group is a set of remotes of which only one, the healthy member with the
+// highest priority, is forwarded at any time. Groups are only used from the
+// distributor loop.
+type group struct {
+	name string
+	// members are ordered by decreasing priority.
+	members    []*remote
+	maxSilence time.Duration
+	active     *remote
+	// gauge is the remote_group_active metric.
+	gauge *prometheus.GaugeVec
+}
+
+// newGroups links remotes with the same group option into groups.
+func newGroups(remotes []*remote, maxSilence time.Duration, gauge *prometheus.GaugeVec) []*group {
+	var groups []*group
+	byName := make(map[string]*group)
+
+	for _, r := range remotes {
+		if r.groupName == "" {
+			continue
+		}
+
+		g := byName[r.groupName]
+		if g == nil {
+			g = &group{name: r.groupName, maxSilence: maxSilence, gauge: gauge}
+			byName[r.groupName] = g
+			groups = append(groups, g)
+		}
+		g.members = append(g.members, r)
+		r.group = g
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g.members, func(i, j int) bool {
+			return g.members[i].priority > g.members[j].priority
+		})
+		for _, r := range g.members {
+			g.gauge.With(prometheus.Labels{"group": g.name, "remote": r.addr}).Set(0)
+		}
+	}
+
+	return groups
+}
+
+// choose makes the highest-priority healthy member active, if it isn't
+// already.
+func (g *group) choose(logger log.Logger, now time.Time) {
+	var best *remote
+	for _, r := range g.members {
+		if r.active(now, g.maxSilence) {
+			best = r
+			break
+		}
+	}
+
+	if best == g.active {
+		return
+	}
+
+	if g.active != nil {
+		g.gauge.With(prometheus.Labels{"group": g.name, "remote": g.active.addr}).Set(0)
+	}
+	if best != nil {
+		g.gauge.With(prometheus.Labels{"group": g.name, "remote": best.addr}).Set(1)
+		level.Info(logger).Log("group", g.name, "active", best.addr)
+	} else {
+		level.Warn(logger).Log("group", g.name, "active", "none")
+	}
+
+	g.active = best
+}
+
+// forward reports whether a message from r should be forwarded, choosing a
+// new active member first if needed.
+func (g *group) forward(logger log.Logger, r *remote, now time.Time) bool {
+	if r != g.active {
+		g.choose(logger, now)
+	}
+
+	return r == g.active
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupFailover(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	primary := &remote{addr: "primary", groupName: "g", priority: 10}
+	standby := &remote{addr: "standby", groupName: "g"}
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "remote_group_active"}, []string{"group", "remote"})
+	// Members are ordered by priority, whatever the order they're given in.
+	groups := newGroups([]*remote{standby, primary}, 30*time.Second, gauge)
+	require.Len(t, groups, 1)
+	g := groups[0]
+	require.Equal(t, []*remote{primary, standby}, g.members)
+
+	remotes := map[string]*remote{"primary": primary, "standby": standby}
+
+	steps := []struct {
+		name string
+		// at is the time of the step, in seconds from the start.
+		at int
+		// heard are the remotes that sent a frame at this time, and
+		// disconnected those whose connection was lost.
+		heard, disconnected []string
+		// from is the remote whose frame is forwarded or not, or empty if
+		// the distributor loop's ticker fires instead.
+		from        string
+		wantForward bool
+		wantActive  string
+	}{
+		{name: "primary first", at: 0, heard: []string{"primary", "standby"}, from: "primary", wantForward: true, wantActive: "primary"},
+		{name: "standby dropped", at: 1, heard: []string{"standby"}, from: "standby", wantForward: false, wantActive: "primary"},
+		{name: "primary silent but within limit", at: 30, heard: []string{"standby"}, from: "standby", wantForward: false, wantActive: "primary"},
+		{name: "primary silent too long", at: 31, heard: []string{"standby"}, from: "standby", wantForward: true, wantActive: "standby"},
+		{name: "standby still active", at: 32, heard: []string{"standby"}, from: "standby", wantForward: true, wantActive: "standby"},
+		{name: "primary returns", at: 33, heard: []string{"primary"}, from: "primary", wantForward: true, wantActive: "primary"},
+		{name: "standby dropped again", at: 34, heard: []string{"standby"}, from: "standby", wantForward: false, wantActive: "primary"},
+		{name: "primary disconnected", at: 35, disconnected: []string{"primary"}, wantActive: "standby"},
+		{name: "all silent", at: 100, wantActive: ""},
+		{name: "standby heard", at: 101, heard: []string{"standby"}, wantActive: "standby"},
+	}
+
+	logger := log.NewNopLogger()
+	for _, step := range steps {
+		now := start.Add(time.Duration(step.at) * time.Second)
+		for _, name := range step.heard {
+			remotes[name].setConnected(true)
+			remotes[name].frameReceived(now)
+		}
+		for _, name := range step.disconnected {
+			remotes[name].setConnected(false)
+		}
+
+		if step.from == "" {
+			g.choose(logger, now)
+		} else {
+			assert.Equal(t, step.wantForward, g.forward(logger, remotes[step.from], now), step.name)
+		}
+
+		if step.wantActive == "" {
+			assert.Nil(t, g.active, step.name)
+		} else if assert.NotNil(t, g.active, step.name) {
+			assert.Equal(t, step.wantActive, g.active.addr, step.name)
+		}
+		for name := range remotes {
+			want := 0.0
+			if name == step.wantActive {
+				want = 1
+			}
+			assert.Equal(t, want, testutil.ToFloat64(gauge.WithLabelValues("g", name)), "%s: %s", step.name, name)
+		}
+	}
+}
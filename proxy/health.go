@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat records when the distributor loop last went round.
+type heartbeat struct {
+	// last is in Unix nanoseconds.
+	last int64
+}
+
+func (h *heartbeat) beat(now time.Time) {
+	atomic.StoreInt64(&h.last, now.UnixNano())
+}
+
+func (h *heartbeat) lastBeat() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&h.last))
+}
+
+type healthJSON struct {
+	Healthy  bool      `json:"healthy"`
+	LastLoop time.Time `json:"last_loop"`
+}
+
+type readyJSON struct {
+	Ready         bool           `json:"ready"`
+	ActiveRemotes int            `json:"active_remotes"`
+	Remotes       []remoteStatus `json:"remotes"`
+}
+
+// healthHandler reports whether the distributor loop is still running.
+func healthHandler(hb *heartbeat, maxStall time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last := hb.lastBeat()
+		h := healthJSON{
+			Healthy:  time.Since(last) <= maxStall,
+			LastLoop: last,
+		}
+
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, h)
+	})
+}
+
+// readyHandler reports whether enough remotes are connected and sending
+// frames for the proxy to be worth connecting to.
+func readyHandler(remotes []*remote, minRemotes int, maxSilence time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		rj := readyJSON{
+			Remotes: make([]remoteStatus, 0, len(remotes)),
+		}
+
+		for _, rem := range remotes {
+			if rem.active(now, maxSilence) {
+				rj.ActiveRemotes++
+			}
+			rj.Remotes = append(rj.Remotes, rem.status())
+		}
+		rj.Ready = rj.ActiveRemotes >= minRemotes
+
+		status := http.StatusOK
+		if !rj.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, rj)
+	})
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve makes a request, returning the status and decoded JSON response.
+func serve(t *testing.T, h http.Handler, v interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	return w.Code
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastBeat   time.Duration
+		wantStatus int
+	}{
+		{name: "fresh", lastBeat: time.Second, wantStatus: http.StatusOK},
+		{name: "stalled", lastBeat: time.Minute, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hb heartbeat
+			last := time.Now().Add(-tt.lastBeat)
+			hb.beat(last)
+
+			var got healthJSON
+			assert.Equal(t, tt.wantStatus, serve(t, healthHandler(&hb, 10*time.Second), &got))
+			assert.Equal(t, tt.wantStatus == http.StatusOK, got.Healthy)
+			assert.True(t, got.LastLoop.Equal(last))
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	now := time.Now()
+	// heard returns a connected remote that last sent a frame ago.
+	heard := func(addr string, ago time.Duration) *remote {
+		r := &remote{addr: addr}
+		r.setConnected(true)
+		r.frameReceived(now.Add(-ago))
+		return r
+	}
+	disconnected := &remote{addr: "disconnected"}
+	disconnected.setError(errors.New("connection refused"))
+
+	tests := []struct {
+		name       string
+		remotes    []*remote
+		minRemotes int
+		wantActive int
+		wantStatus int
+	}{
+		{name: "enough", remotes: []*remote{heard("a", time.Second), heard("b", 2*time.Second)}, minRemotes: 2, wantActive: 2, wantStatus: http.StatusOK},
+		{name: "more than enough", remotes: []*remote{heard("a", time.Second), heard("b", time.Second), disconnected}, minRemotes: 1, wantActive: 2, wantStatus: http.StatusOK},
+		{name: "too few heard", remotes: []*remote{heard("a", time.Second), heard("b", 2*time.Minute)}, minRemotes: 2, wantActive: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "too few connected", remotes: []*remote{heard("a", time.Second), disconnected}, minRemotes: 2, wantActive: 1, wantStatus: http.StatusServiceUnavailable},
+		{name: "none needed", remotes: []*remote{disconnected}, minRemotes: 0, wantActive: 0, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got readyJSON
+			assert.Equal(t, tt.wantStatus, serve(t, readyHandler(tt.remotes, tt.minRemotes, time.Minute), &got))
+			assert.Equal(t, tt.wantStatus == http.StatusOK, got.Ready)
+			assert.Equal(t, tt.wantActive, got.ActiveRemotes)
+			assert.Len(t, got.Remotes, len(tt.remotes))
+		})
+	}
+
+	var got readyJSON
+	serve(t, readyHandler([]*remote{heard("a", time.Second), disconnected}, 1, time.Minute), &got)
+	assert.True(t, got.Remotes[0].Connected)
+	assert.NotNil(t, got.Remotes[0].LastFrame)
+	assert.Equal(t, uint64(1), got.Remotes[0].Frames)
+	assert.False(t, got.Remotes[1].Connected)
+	assert.Nil(t, got.Remotes[1].LastFrame)
+	assert.Equal(t, "connection refused", got.Remotes[1].LastError)
+}
@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"encoding/hex"
@@ -32,7 +32,7 @@ func (l loggingReader) Read(p []byte) (n int, err error) {
 	return
 }
 
-func NewLoggingReader(r io.Reader, out io.Writer) io.ReadCloser {
+func newLoggingReader(r io.Reader, out io.Writer) io.ReadCloser {
 	return loggingReader{
 		r:   r,
 		out: out,
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"dump1090-proxy/aircraft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metrics are the Prometheus metrics of a single Proxy.
+type metrics struct {
+	messagesRead        prometheus.Counter
+	messagesWritten     prometheus.Counter
+	inboundConnections  prometheus.Gauge
+	outboundConnections prometheus.Gauge
+	messagesFiltered    *prometheus.CounterVec
+	ioErrors            *prometheus.CounterVec
+
+	connectionsRejected *prometheus.CounterVec
+	compressionBytes    *prometheus.CounterVec
+
+	groupActive     *prometheus.GaugeVec
+	messagesStandby prometheus.Counter
+
+	messagesRecorded      prometheus.Counter
+	messagesRecordDropped prometheus.Counter
+
+	websocketSubscribers    prometheus.Gauge
+	websocketDropped        prometheus.Counter
+	frameSubscribers        prometheus.Gauge
+	frameSubscribersDropped prometheus.Counter
+}
+
+// newMetrics creates the metrics and registers them with reg, if it isn't
+// nil.
+func newMetrics(reg prometheus.Registerer, tracker *aircraft.Tracker) *metrics {
+	f := promauto.With(reg)
+
+	f.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "aircraft_tracked",
+		Help: "Number of aircraft currently being tracked",
+	}, func() float64 {
+		return float64(tracker.Len())
+	})
+	f.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "aircraft_with_position",
+		Help: "Number of aircraft currently being tracked whose position is known",
+	}, func() float64 {
+		n := 0
+		for _, a := range tracker.All() {
+			if a.HasPosition {
+				n++
+			}
+		}
+		return float64(n)
+	})
+
+	return &metrics{
+		messagesRead: f.NewCounter(prometheus.CounterOpts{
+			Name: "messages_read",
+			Help: "The total number of dump1090 messages read from source",
+		}),
+		messagesWritten: f.NewCounter(prometheus.CounterOpts{
+			Name: "messages_written",
+			Help: "The total number of dump1090 messages written to clients",
+		}),
+		inboundConnections: f.NewGauge(prometheus.GaugeOpts{
+			Name: "inbound_connections",
+			Help: "Number of inbound connections",
+		}),
+		outboundConnections: f.NewGauge(prometheus.GaugeOpts{
+			Name: "outbound_connections",
+			Help: "Number of outbound connections",
+		}),
+		messagesFiltered: f.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "messages_filtered",
+				Help: "The total number of dump1090 messages not forwarded to a listener's clients",
+			},
+			[]string{"listener", "reason"},
+		),
+		ioErrors: f.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ioerrors_total",
+				Help: `Total IO errors`,
+			},
+			[]string{"op"},
+		),
+
+		connectionsRejected: f.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "connections_rejected",
+				Help: "The total number of client connections refused by a listener",
+			},
+			[]string{"listener", "reason"},
+		),
+		compressionBytes: f.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "compression_bytes",
+				Help: "Bytes passing through compressed links, before (uncompressed) and after (compressed) compression",
+			},
+			[]string{"link", "direction", "stage"},
+		),
+
+		groupActive: f.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "remote_group_active",
+				Help: "1 for the remote currently forwarded by each failover group, 0 for its standbys",
+			},
+			[]string{"group", "remote"},
+		),
+		messagesStandby: f.NewCounter(prometheus.CounterOpts{
+			Name: "messages_standby",
+			Help: "The total number of dump1090 messages discarded because they came from a standby remote",
+		}),
+
+		messagesRecorded: f.NewCounter(prometheus.CounterOpts{
+			Name: "messages_recorded",
+			Help: "The total number of dump1090 messages written to capture files",
+		}),
+		messagesRecordDropped: f.NewCounter(prometheus.CounterOpts{
+			Name: "messages_record_dropped",
+			Help: "The total number of dump1090 messages not recorded because the recorder was busy or failing",
+		}),
+
+		websocketSubscribers: f.NewGauge(prometheus.GaugeOpts{
+			Name: "websocket_subscribers",
+			Help: "Number of connected websocket subscribers",
+		}),
+		websocketDropped: f.NewCounter(prometheus.CounterOpts{
+			Name: "websocket_subscribers_dropped",
+			Help: "The total number of websocket subscribers disconnected for being too slow",
+		}),
+		frameSubscribers: f.NewGauge(prometheus.GaugeOpts{
+			Name: "frame_subscribers",
+			Help: "Number of connected Server-Sent Events frame subscribers",
+		}),
+		frameSubscribersDropped: f.NewCounter(prometheus.CounterOpts{
+			Name: "frame_subscribers_dropped",
+			Help: "The total number of frame subscribers disconnected for being too slow",
+		}),
+	}
+}
@@ -0,0 +1,829 @@
+// Package proxy aggregates beast streams from a number of remote receivers and
+// serves the result to clients, as used by the dump1090-proxy command.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/capture"
+	"dump1090-proxy/spec"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configure a Proxy. Zero values select the defaults given.
+type Options struct {
+	// Listeners are the addresses on which clients connect, each optionally
+	// followed by ?option=value&... as described in the README.
+	Listeners []string
+	// Remotes are the receivers to aggregate, with options in the same form.
+	Remotes []string
+
+	// Logger defaults to discarding everything.
+	Logger log.Logger
+	// Registerer is where the proxy's metrics are registered. If nil they are
+	// not registered.
+	Registerer prometheus.Registerer
+
+	// DumpMessages hex-dumps every message read.
+	DumpMessages bool
+	// FlushInterval is how often compressed client connections are flushed
+	// (250ms).
+	FlushInterval time.Duration
+	// WriteTimeout is how long a client may block the distributor loop before
+	// it is disconnected (2s).
+	WriteTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before reconnecting to a
+	// remote (1s and 1m).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// FailoverMaxSilence is how long a failover group's active remote may
+	// send nothing before another takes over (30s).
+	FailoverMaxSilence time.Duration
+	// AircraftExpiry is how long an aircraft is remembered after its last
+	// message (60s).
+	AircraftExpiry time.Duration
+
+	// Record configures capture files. Recording is disabled if Record.Dir is
+	// empty.
+	Record capture.Options
+	// RecordAll records every remote, not just those with record=true.
+	RecordAll bool
+
+	// ReceiverLat and ReceiverLon are reported in receiver.json unless both
+	// are zero, and are used to decode the positions of aircraft on the
+	// ground.
+	ReceiverLat float64
+	ReceiverLon float64
+
+	// Paths served by Handler.
+	DataPath      string // "/data"
+	WebsocketPath string // "/ws"
+	FramesPath    string // "/frames"
+	HealthPath    string // "/healthz"
+	ReadyPath     string // "/readyz"
+
+	// MaxStall is how long the distributor loop may not run before the proxy
+	// reports itself unhealthy (30s).
+	MaxStall time.Duration
+	// ReadyMinRemotes is the number of remotes that must be active for the
+	// proxy to report itself ready. Zero means always ready.
+	ReadyMinRemotes int
+	// ReadyMaxSilence is how long a remote may send nothing and still count
+	// as active (60s).
+	ReadyMaxSilence time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Logger == nil {
+		o.Logger = log.NewNopLogger()
+	}
+
+	durations := []struct {
+		d   *time.Duration
+		def time.Duration
+	}{
+		{&o.FlushInterval, 250 * time.Millisecond},
+		{&o.WriteTimeout, 2 * time.Second},
+		{&o.MinBackoff, time.Second},
+		{&o.MaxBackoff, time.Minute},
+		{&o.FailoverMaxSilence, 30 * time.Second},
+		{&o.AircraftExpiry, 60 * time.Second},
+		{&o.MaxStall, 30 * time.Second},
+		{&o.ReadyMaxSilence, 60 * time.Second},
+	}
+	for _, d := range durations {
+		if *d.d == 0 {
+			*d.d = d.def
+		}
+	}
+
+	paths := []struct {
+		p   *string
+		def string
+	}{
+		{&o.DataPath, "/data"},
+		{&o.WebsocketPath, "/ws"},
+		{&o.FramesPath, "/frames"},
+		{&o.HealthPath, "/healthz"},
+		{&o.ReadyPath, "/readyz"},
+	}
+	for _, p := range paths {
+		if *p.p == "" {
+			*p.p = p.def
+		}
+	}
+
+	return o
+}
+
+// Proxy forwards the frames from its remotes to the clients of its listeners.
+type Proxy struct {
+	heartbeat heartbeat
+
+	opts      Options
+	logger    log.Logger
+	metrics   *metrics
+	listeners []*listener
+	remotes   []*remote
+	groups    []*group
+	tracker   *aircraft.Tracker
+	hub       *hub
+	frames    *frameFeed
+	recorder  *recorder
+}
+
+// New checks the options and opens the listeners, ready for Run.
+func New(opts Options) (*Proxy, error) {
+	opts = opts.withDefaults()
+
+	var remotes []*remote
+	for _, addr := range opts.Remotes {
+		r, err := newRemote(addr)
+		if err != nil {
+			return nil, fmt.Errorf("remote: %w", err)
+		}
+		remotes = append(remotes, r)
+	}
+
+	tracker := aircraft.NewTracker(opts.AircraftExpiry)
+	if opts.ReceiverLat != 0 || opts.ReceiverLon != 0 {
+		tracker.SetReference(opts.ReceiverLat, opts.ReceiverLon)
+	}
+	m := newMetrics(opts.Registerer, tracker)
+
+	p := &Proxy{
+		opts:    opts,
+		logger:  opts.Logger,
+		metrics: m,
+		remotes: remotes,
+		groups:  newGroups(remotes, opts.FailoverMaxSilence, m.groupActive),
+		tracker: tracker,
+		hub:     newHub(tracker, m),
+		frames:  newFrameFeed(tracker, m),
+	}
+
+	if opts.Record.Dir != "" {
+		var err error
+		if p.recorder, err = newRecorder(opts.Record, opts.RecordAll, m); err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+	} else if opts.RecordAll {
+		return nil, fmt.Errorf("recording all remotes requires a directory")
+	} else {
+		for _, r := range remotes {
+			if r.record {
+				return nil, fmt.Errorf("remote: %s: record requires a directory", r.addr)
+			}
+		}
+	}
+
+	for _, addr := range opts.Listeners {
+		l, err := p.newListener(addr)
+		if err == nil && l.timestampSource != "" && !hasRemote(remotes, l.timestampSource) {
+			l.l.Close()
+			err = fmt.Errorf("%s: timestamp-source %s is not a remote", l.addr, l.timestampSource)
+		}
+		if err != nil {
+			p.closeListeners()
+			return nil, fmt.Errorf("listen address: %w", err)
+		}
+		p.listeners = append(p.listeners, l)
+	}
+
+	return p, nil
+}
+
+func hasRemote(remotes []*remote, addr string) bool {
+	for _, r := range remotes {
+		if r.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) closeListeners() {
+	for _, l := range p.listeners {
+		l.l.Close()
+	}
+}
+
+// ListenAddrs returns the addresses of the listeners, in the order given in
+// the options. This is useful when listening on port 0.
+func (p *Proxy) ListenAddrs() []net.Addr {
+	addrs := make([]net.Addr, len(p.listeners))
+	for i, l := range p.listeners {
+		addrs[i] = l.l.Addr()
+	}
+	return addrs
+}
+
+// Tracker returns the state of the aircraft seen by the proxy.
+func (p *Proxy) Tracker() *aircraft.Tracker {
+	return p.tracker
+}
+
+// Handler serves aircraft.json, receiver.json, the live feeds and the health
+// checks, at the paths given in the options.
+func (p *Proxy) Handler() http.Handler {
+	var lat, lon *float64
+	if p.opts.ReceiverLat != 0 || p.opts.ReceiverLon != 0 {
+		lat, lon = &p.opts.ReceiverLat, &p.opts.ReceiverLon
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(p.opts.HealthPath, healthHandler(&p.heartbeat, p.opts.MaxStall))
+	mux.Handle(p.opts.ReadyPath, readyHandler(p.remotes, p.opts.ReadyMinRemotes, p.opts.ReadyMaxSilence))
+	mux.Handle(path.Join(p.opts.DataPath, "aircraft.json"), aircraftHandler(p.tracker))
+	mux.Handle(path.Join(p.opts.DataPath, "receiver.json"), receiverHandler(lat, lon))
+	mux.Handle(p.opts.WebsocketPath, websocketHandler(p.logger, p.hub))
+	mux.Handle(p.opts.FramesPath, frameFeedHandler(p.logger, p.frames))
+	return mux
+}
+
+// Run connects to the remotes and serves clients until ctx is cancelled. It
+// then closes every connection and returns once everything has stopped. A
+// Proxy can only be run once.
+func (p *Proxy) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	newConnection := make(chan client, 4)
+	for i, l := range p.listeners {
+		wg.Add(1)
+		go func(l *listener, i int) {
+			defer wg.Done()
+			p.runListener(ctx, &wg, l, i, newConnection)
+		}(l, i)
+	}
+
+	newMessage := make(chan message, 16)
+	for _, r := range p.remotes {
+		wg.Add(1)
+		go func(r *remote) {
+			defer wg.Done()
+			p.runRemote(ctx, r, newMessage)
+		}(r)
+	}
+
+	// The recorder stops once the distributor has, so that it can write
+	// everything the distributor queued.
+	recordCtx, stopRecording := context.WithCancel(context.Background())
+	defer stopRecording()
+	if p.recorder != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.recorder.run(recordCtx, p.logger)
+		}()
+	}
+
+	p.distribute(ctx, newConnection, newMessage)
+
+	cancel()
+	stopRecording()
+	wg.Wait()
+	p.hub.close()
+	p.frames.close()
+
+	return nil
+}
+
+// listener is a local address on which clients connect to receive the
+// aggregated stream.
+type listener struct {
+	addr     string
+	l        *net.TCPListener
+	tls      *tls.Config
+	access   access
+	compress string
+	// receiverIDs says whether to precede each frame with a receiver ID frame.
+	receiverIDs     bool
+	timestamps      string
+	timestampSource string
+	filter          filter
+}
+
+func (p *Proxy) newListener(arg string) (*listener, error) {
+	s, err := spec.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parseFilter(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	tlsConfig, err := parseServerTLS(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	a, err := parseAccess(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	compress, err := parseCompression(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	receiverIDs := s.Bool("receiver-ids", false)
+	timestamps, timestampSource, err := parseTimestamps(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener{
+		addr:            s.Name,
+		l:               l.(*net.TCPListener),
+		tls:             tlsConfig,
+		access:          a,
+		compress:        compress,
+		receiverIDs:     receiverIDs,
+		timestamps:      timestamps,
+		timestampSource: timestampSource,
+		filter:          f,
+	}, nil
+}
+
+// accept reports whether a message should be forwarded to the listener's
+// clients and, if not, why.
+func (l *listener) accept(m message, tracker *aircraft.Tracker) (bool, string) {
+	if l.timestampSource != "" && m.remote.addr != l.timestampSource {
+		return false, reasonTimestampSource
+	}
+
+	return l.filter.accept(m.frame, tracker)
+}
+
+// wrap applies the listener's compression, if any, to a client connection.
+func (p *Proxy) wrap(l *listener, conn net.Conn) net.Conn {
+	if l.compress == compressNone {
+		return conn
+	}
+	return newCompressedConn(conn, l.compress, l.addr, p.metrics.compressionBytes)
+}
+
+// client is a connection accepted by one of the listeners.
+type client struct {
+	conn     net.Conn
+	listener int
+}
+
+// message is a single beast message read from a remote.
+type message struct {
+	remote     *remote
+	receiverID uint64
+	received   time.Time
+	raw        []byte
+	frame      beast.Frame
+}
+
+// distribute is the distributor loop, which sends each message to the
+// clients that want it. It returns, having closed all the clients, when ctx
+// is cancelled.
+func (p *Proxy) distribute(ctx context.Context, newConnection <-chan client, newMessage <-chan message) {
+	logger, listeners := p.logger, p.listeners
+
+	expiry := time.NewTicker(10 * time.Second)
+	defer expiry.Stop()
+
+	// Make sure the loop goes round regularly even when there are no
+	// messages, so that the health check can tell it isn't stuck.
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	flush := time.NewTicker(p.opts.FlushInterval)
+	defer flush.Stop()
+
+	clients := make(map[net.Conn]int)
+	counts := make([]int, len(listeners))
+	accepted := make([]bool, len(listeners))
+	rendered := make([][]byte, len(listeners))
+
+	drop := func(c net.Conn, err error) {
+		p.ioError(c.RemoteAddr(), "write", err)
+		counts[clients[c]]--
+		delete(clients, c)
+		c.Close()
+	}
+
+	defer func() {
+		for c := range clients {
+			c.Close()
+		}
+		p.metrics.inboundConnections.Set(0)
+	}()
+
+	for {
+		p.heartbeat.beat(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			// Notice silent or disconnected remotes promptly.
+			for _, g := range p.groups {
+				g.choose(logger, now)
+			}
+		case <-flush.C:
+			for c := range clients {
+				f, ok := c.(interface{ Flush() error })
+				if !ok {
+					continue
+				}
+
+				c.SetWriteDeadline(time.Now().Add(p.opts.WriteTimeout))
+				if err := f.Flush(); err != nil {
+					drop(c, err)
+				}
+			}
+		case nc := <-newConnection:
+			c, l := nc.conn, listeners[nc.listener]
+			if l.access.maxClients > 0 && counts[nc.listener] >= l.access.maxClients {
+				level.Warn(logger).Log("rejected", c.RemoteAddr(), "listener", l.addr, "reason", rejectMaxClients)
+				p.reject(l.addr, rejectMaxClients)
+				c.Close()
+				break
+			}
+
+			level.Info(logger).Log("new_conn", c.RemoteAddr(), "listener", l.addr)
+			clients[c] = nc.listener
+			counts[nc.listener]++
+		case now := <-expiry.C:
+			p.hub.expire(p.tracker.Expire(now))
+		case m := <-newMessage:
+			p.recorder.record(m)
+
+			if g := m.remote.group; g != nil && !g.forward(logger, m.remote, m.received) {
+				p.metrics.messagesStandby.Inc()
+				break
+			}
+
+			if p.opts.DumpMessages {
+				level.Debug(logger).Log("message", hex.EncodeToString(m.raw))
+			}
+
+			p.tracker.Update(m.remote.addr, m.frame, time.Now())
+			p.hub.publish(m)
+			p.frames.publish(m)
+
+			for i, l := range listeners {
+				ok, reason := l.accept(m, p.tracker)
+				if !ok {
+					p.metrics.messagesFiltered.With(prometheus.Labels{
+						"listener": l.addr,
+						"reason":   reason,
+					}).Inc()
+				}
+				accepted[i] = ok
+				rendered[i] = nil
+			}
+
+			for c, l := range clients {
+				if !accepted[l] {
+					continue
+				}
+
+				if rendered[l] == nil {
+					rendered[l] = listeners[l].render(m)
+				}
+
+				c.SetWriteDeadline(time.Now().Add(p.opts.WriteTimeout))
+				if _, err := c.Write(rendered[l]); err != nil {
+					drop(c, err)
+				}
+			}
+
+			p.metrics.messagesWritten.Inc()
+		}
+
+		p.metrics.inboundConnections.Set(float64(len(clients)))
+	}
+}
+
+// runListener accepts clients until ctx is cancelled. Handshakes run in the
+// background, tracked by wg.
+func (p *Proxy) runListener(ctx context.Context, wg *sync.WaitGroup, l *listener, index int, ch chan<- client) {
+	logger := p.logger
+
+	go func() {
+		<-ctx.Done()
+		l.l.Close()
+	}()
+
+	// deliver hands a new client to the distributor loop.
+	deliver := func(c net.Conn) {
+		select {
+		case ch <- client{conn: p.wrap(l, c), listener: index}:
+		case <-ctx.Done():
+			c.Close()
+		}
+	}
+
+	for {
+		conn, err := l.l.AcceptTCP()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			level.Error(logger).Log("listener", l.addr, "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if !l.access.permitted(conn.RemoteAddr()) {
+			level.Warn(logger).Log("rejected", conn.RemoteAddr(), "listener", l.addr, "reason", rejectAddress)
+			p.reject(l.addr, rejectAddress)
+			conn.Close()
+			continue
+		}
+
+		conn.SetKeepAlive(true)
+		conn.SetKeepAlivePeriod(time.Minute)
+
+		if l.tls == nil && l.access.token == "" {
+			conn.CloseRead()
+			deliver(conn)
+			continue
+		}
+
+		// Handshake in the background so that a slow client can't hold up
+		// others.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, reason, err := admit(l, conn)
+			if err != nil {
+				level.Warn(logger).Log("rejected", conn.RemoteAddr(), "listener", l.addr, "reason", reason, "err", err)
+				p.reject(l.addr, reason)
+				conn.Close()
+				return
+			}
+			deliver(c)
+		}()
+	}
+}
+
+// admit completes the TLS and token handshakes of a new client, returning the
+// connection to write to or the reason it was rejected.
+func admit(l *listener, conn *net.TCPConn) (net.Conn, string, error) {
+	var c net.Conn = conn
+	if l.tls != nil {
+		tlsConn := tls.Server(conn, l.tls)
+		tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, rejectHandshake, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		c = tlsConn
+	}
+
+	if l.access.token != "" {
+		if err := l.access.checkToken(c); err != nil {
+			return nil, rejectToken, err
+		}
+	}
+
+	if l.tls == nil {
+		conn.CloseRead()
+	}
+
+	return c, "", nil
+}
+
+func (p *Proxy) reject(listenerAddr string, reason string) {
+	p.metrics.connectionsRejected.With(prometheus.Labels{
+		"listener": listenerAddr,
+		"reason":   reason,
+	}).Inc()
+}
+
+// runRemote keeps connecting to the remote until ctx is cancelled.
+func (p *Proxy) runRemote(ctx context.Context, r *remote, ch chan<- message) {
+	logger := p.logger
+	backoff := time.Duration(0)
+	lastErrorLog := time.Time{}
+
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Now().After(lastErrorLog.Add(time.Hour)) {
+			level.Info(logger).Log("addr", r.addr, "action", "connecting")
+		}
+
+		conn, err := dialRemote(ctx, r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.setError(err)
+			if time.Now().After(lastErrorLog.Add(time.Hour)) {
+				level.Error(logger).Log("addr", r.addr, "err", err)
+				lastErrorLog = time.Now()
+			}
+
+			backoff = p.increaseBackoff(backoff)
+			continue
+		}
+
+		level.Info(logger).Log("addr", r.addr, "action", "connected")
+		if p.runRemoteConnection(ctx, r, conn, ch) {
+			backoff = time.Duration(0)
+		} else {
+			// The remote closed the connection without sending anything,
+			// perhaps because it rejected us, so don't hammer it.
+			backoff = p.increaseBackoff(backoff)
+		}
+	}
+}
+
+func (p *Proxy) increaseBackoff(backoff time.Duration) time.Duration {
+	backoff = (p.opts.MinBackoff + backoff) * 2
+	if backoff > p.opts.MaxBackoff {
+		backoff = p.opts.MaxBackoff
+	}
+	return backoff
+}
+
+// dialRemote connects to the remote, completing the TLS handshake if needed.
+func dialRemote(ctx context.Context, r *remote) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpConn := conn.(*net.TCPConn)
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(time.Minute)
+
+	conn = tcpConn
+	if r.tls != nil {
+		tlsConn := tls.Client(tcpConn, r.tls)
+		tlsConn.SetDeadline(time.Now().Add(30 * time.Second))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			tcpConn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	if r.token != "" {
+		if err := sendToken(conn, r.token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if r.tls == nil {
+		tcpConn.CloseWrite()
+	}
+
+	return conn, nil
+}
+
+// runRemoteConnection reads messages until the connection fails or ctx is
+// cancelled, returning whether any were received.
+func (p *Proxy) runRemoteConnection(ctx context.Context, rem *remote, conn net.Conn, ch chan<- message) bool {
+	logger := p.logger
+
+	defer conn.Close()
+	defer level.Warn(logger).Log("addr", conn.RemoteAddr().String(), "action", "disconnected")
+
+	// Closing the connection is the only way to interrupt a read.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	rem.setConnected(true)
+	defer rem.setConnected(false)
+
+	p.metrics.outboundConnections.Inc()
+	defer p.metrics.outboundConnections.Dec()
+
+	var r io.Reader = conn
+	if rem.compress != compressNone {
+		var err error
+		if r, err = newDecompressingReader(r, rem.compress, rem.addr, p.metrics.compressionBytes); err != nil {
+			p.ioError(conn.RemoteAddr(), "read", err)
+			rem.setError(err)
+			return false
+		}
+	}
+	if p.opts.DumpMessages {
+		r = newLoggingReader(r, os.Stderr)
+	}
+
+	br := bufio.NewReader(r)
+
+	// A remote that is itself aggregating may tell us the original receiver
+	// in a receiver ID frame. This applies only to the frame that follows it,
+	// so that frames without one are never attributed to an earlier receiver.
+	var incomingID uint64
+
+	seenFirstMessage := false
+	for {
+		b, err := beast.ReadMessage(br)
+		if err, ok := err.(beast.InvalidMessage); ok {
+			// Don't log warning if we have just connected - may get partial messages.
+			if seenFirstMessage {
+				level.Warn(logger).Log("err", err)
+			}
+
+			// ReadMessage will have consumed at least one byte, so try again with remaining buffer
+			continue
+		}
+
+		if err != nil {
+			if ctx.Err() == nil {
+				p.ioError(conn.RemoteAddr(), "read", err)
+				rem.setError(err)
+			}
+			return seenFirstMessage
+		}
+
+		if b == nil {
+			// A frame type we don't understand, so can't safely forward.
+			continue
+		}
+
+		frame, err := beast.ParseFrame(b)
+		if err != nil {
+			level.Warn(logger).Log("err", err)
+			continue
+		}
+
+		if frame.Type == beast.ReceiverID {
+			incomingID = frame.ReceiverID()
+			continue
+		}
+
+		receiverID := incomingID
+		incomingID = 0
+		if receiverID == 0 {
+			receiverID = rem.id
+		}
+
+		if !seenFirstMessage {
+			level.Info(logger).Log("addr", conn.RemoteAddr().String(), "action", "seenFirstMessage")
+		}
+
+		seenFirstMessage = true
+		p.metrics.messagesRead.Inc()
+		now := time.Now()
+		rem.frameReceived(now)
+
+		select {
+		case ch <- message{remote: rem, receiverID: receiverID, received: now, raw: b, frame: frame}:
+		case <-ctx.Done():
+			return seenFirstMessage
+		}
+	}
+}
+
+func (p *Proxy) ioError(addr interface{}, op string, err error) {
+	level.Error(p.logger).Log("addr", addr, "op", op, "err", err)
+	p.metrics.ioErrors.With(prometheus.Labels{
+		"op": op,
+	}).Inc()
+}
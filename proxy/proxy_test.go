@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const waitFor = 5 * time.Second
+
+// identification returns a beast frame identifying an aircraft.
+func identification(icao uint32, callsign string) []byte {
+	return beast.Frame{
+		Type:   beast.ModeSLong,
+		Signal: 0x80,
+		Data:   modes.EncodeIdentification(icao, "A3", callsign),
+	}.Bytes()
+}
+
+// fakeRemote is a beast server on loopback standing in for a receiver.
+type fakeRemote struct {
+	l     net.Listener
+	conns chan net.Conn
+	// accepted holds the time of each connection.
+	mu       sync.Mutex
+	accepted []time.Time
+}
+
+func newFakeRemote(t *testing.T) *fakeRemote {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	r := &fakeRemote{l: l, conns: make(chan net.Conn, 100)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.accepted = append(r.accepted, time.Now())
+			r.mu.Unlock()
+			r.conns <- c
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+
+	return r
+}
+
+func (r *fakeRemote) addr() string {
+	return r.l.Addr().String()
+}
+
+// next returns the next connection from the proxy.
+func (r *fakeRemote) next(t *testing.T) net.Conn {
+	select {
+	case c := <-r.conns:
+		t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(waitFor):
+		t.Fatal("proxy did not connect")
+		return nil
+	}
+}
+
+func (r *fakeRemote) connectionTimes() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Time(nil), r.accepted...)
+}
+
+// testProxy is a Proxy running in the background.
+type testProxy struct {
+	*Proxy
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func startProxy(t *testing.T, opts Options) *testProxy {
+	if opts.Listeners == nil {
+		opts.Listeners = []string{"127.0.0.1:0"}
+	}
+	opts.Registerer = prometheus.NewRegistry()
+
+	p, err := New(opts)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tp := &testProxy{Proxy: p, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		tp.done <- p.Run(ctx)
+	}()
+	t.Cleanup(func() { tp.stop(t) })
+
+	return tp
+}
+
+// stop shuts the proxy down, failing if it takes too long.
+func (tp *testProxy) stop(t *testing.T) {
+	tp.cancel()
+	select {
+	case err, ok := <-tp.done:
+		if ok {
+			assert.NoError(t, err)
+			close(tp.done)
+		}
+	case <-time.After(waitFor):
+		t.Fatal("proxy did not stop")
+	}
+}
+
+// dial connects a client to the i'th listener and waits until the proxy has
+// registered it.
+func (tp *testProxy) dial(t *testing.T, i int) net.Conn {
+	want := testutil.ToFloat64(tp.metrics.inboundConnections) + 1
+
+	c, err := net.Dial("tcp", tp.ListenAddrs()[i].String())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(tp.metrics.inboundConnections) == want
+	}, waitFor, 10*time.Millisecond)
+
+	return c
+}
+
+// readFrames reads n frames from a client connection.
+func readFrames(t *testing.T, c net.Conn, n int) []string {
+	c.SetReadDeadline(time.Now().Add(waitFor))
+	r := bufio.NewReader(c)
+
+	var frames []string
+	for len(frames) < n {
+		msg, err := beast.ReadMessage(r)
+		require.NoError(t, err)
+		frames = append(frames, string(msg))
+	}
+	return frames
+}
+
+func TestAggregation(t *testing.T) {
+	r1, r2 := newFakeRemote(t), newFakeRemote(t)
+	tp := startProxy(t, Options{Remotes: []string{r1.addr(), r2.addr()}})
+
+	c1, c2 := r1.next(t), r2.next(t)
+	client := tp.dial(t, 0)
+
+	f1, f2 := identification(0x400001, "ONE"), identification(0x400002, "TWO")
+	_, err := c1.Write(f1)
+	require.NoError(t, err)
+	_, err = c2.Write(f2)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{string(f1), string(f2)}, readFrames(t, client, 2))
+
+	a, ok := tp.Tracker().Get(0x400001)
+	require.True(t, ok)
+	assert.Equal(t, "ONE", a.Callsign)
+	assert.Equal(t, []string{r1.addr()}, a.Remotes)
+	a, ok = tp.Tracker().Get(0x400002)
+	require.True(t, ok)
+	assert.Equal(t, []string{r2.addr()}, a.Remotes)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(tp.metrics.messagesRead))
+}
+
+func TestFilteredListener(t *testing.T) {
+	r := newFakeRemote(t)
+	tp := startProxy(t, Options{
+		Remotes:   []string{r.addr()},
+		Listeners: []string{"127.0.0.1:0", "127.0.0.1:0?deny-icao=400001"},
+	})
+
+	rc := r.next(t)
+	all, filtered := tp.dial(t, 0), tp.dial(t, 1)
+
+	f1, f2 := identification(0x400001, "ONE"), identification(0x400002, "TWO")
+	_, err := rc.Write(append(append([]byte(nil), f1...), f2...))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{string(f1), string(f2)}, readFrames(t, all, 2))
+	assert.Equal(t, []string{string(f2)}, readFrames(t, filtered, 1))
+}
+
+func TestReceiverIDs(t *testing.T) {
+	r := newFakeRemote(t)
+	tp := startProxy(t, Options{
+		Remotes:   []string{r.addr()},
+		Listeners: []string{"127.0.0.1:0", "127.0.0.1:0?receiver-ids=true"},
+	})
+
+	rc := r.next(t)
+	plain, tagged := tp.dial(t, 0), tp.dial(t, 1)
+
+	// Only the first frame comes with a receiver ID, so the second is
+	// attributed to the remote itself.
+	id := beast.NewReceiverIDFrame(0x1234).Bytes()
+	f1, f2 := identification(0x400001, "ONE"), identification(0x400002, "TWO")
+	_, err := rc.Write(append(append(append([]byte(nil), id...), f1...), f2...))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{string(f1), string(f2)}, readFrames(t, plain, 2))
+	assert.Equal(t, []string{
+		string(id), string(f1),
+		string(beast.NewReceiverIDFrame(parseReceiverID(r.addr())).Bytes()), string(f2),
+	}, readFrames(t, tagged, 4))
+}
+
+func TestFailover(t *testing.T) {
+	r1, r2 := newFakeRemote(t), newFakeRemote(t)
+	tp := startProxy(t, Options{
+		Remotes:            []string{r1.addr() + "?group=g&priority=1", r2.addr() + "?group=g"},
+		FailoverMaxSilence: 5 * time.Second,
+	})
+
+	primary, standby := r1.next(t), r2.next(t)
+	client := tp.dial(t, 0)
+
+	f1, f2, f3 := identification(0x400001, "ONE"), identification(0x400002, "TWO"), identification(0x400003, "THREE")
+	_, err := primary.Write(f1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{string(f1)}, readFrames(t, client, 1))
+
+	// The standby's frames are dropped while the primary is healthy.
+	_, err = standby.Write(f2)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(tp.metrics.messagesStandby) == 1
+	}, waitFor, 10*time.Millisecond)
+
+	// Kill the primary, so that it can't reconnect either.
+	r1.l.Close()
+	primary.Close()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(tp.metrics.groupActive.WithLabelValues("g", r2.addr())) == 1
+	}, waitFor, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(tp.metrics.groupActive.WithLabelValues("g", r1.addr())))
+
+	_, err = standby.Write(f3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{string(f3)}, readFrames(t, client, 1))
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	r := newFakeRemote(t)
+	startProxy(t, Options{
+		Remotes:    []string{r.addr()},
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: time.Second,
+	})
+
+	// A remote that closes the connection without sending anything is
+	// retried less and less often.
+	for i := 0; i < 5; i++ {
+		r.next(t).Close()
+	}
+
+	// Once data flows, the backoff resets.
+	c := r.next(t)
+	_, err := c.Write(identification(0x400001, "ONE"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	r.next(t)
+
+	at := r.connectionTimes()
+	require.Len(t, at, 7)
+	var gaps []time.Duration
+	for i := 1; i < len(at); i++ {
+		gaps = append(gaps, at[i].Sub(at[i-1]))
+	}
+
+	// Nominally 20ms, 60ms, 140ms, 300ms and 620ms.
+	assert.Greater(t, int64(gaps[4]), int64(2*gaps[1]), "backoff should grow: %v", gaps)
+	assert.GreaterOrEqual(t, int64(gaps[4]), int64(500*time.Millisecond), "%v", gaps)
+	// Includes the 50ms the connection was open.
+	assert.Less(t, int64(gaps[5]), int64(gaps[4]), "backoff should reset: %v", gaps)
+}
+
+func TestSlowClient(t *testing.T) {
+	r := newFakeRemote(t)
+	tp := startProxy(t, Options{
+		Remotes:      []string{r.addr()},
+		WriteTimeout: 100 * time.Millisecond,
+	})
+
+	rc := r.next(t)
+	fast := tp.dial(t, 0)
+	slow := tp.dial(t, 0)
+	slow.(*net.TCPConn).SetReadBuffer(1024)
+
+	// Keep the fast client reading.
+	var mu sync.Mutex
+	received := 0
+	go func() {
+		br := bufio.NewReader(fast)
+		for {
+			if _, err := beast.ReadMessage(br); err != nil {
+				return
+			}
+			mu.Lock()
+			received++
+			mu.Unlock()
+		}
+	}()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+
+	// Send until the slow client's buffers fill up and it is dropped.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		f := identification(0x400001, "ONE")
+		batch := make([]byte, 0, 100*len(f))
+		for i := 0; i < 100; i++ {
+			batch = append(batch, f...)
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := rc.Write(batch); err != nil {
+				return
+			}
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(tp.metrics.inboundConnections) == 1
+	}, 4*waitFor, 10*time.Millisecond, "slow client not dropped")
+	assert.GreaterOrEqual(t, testutil.ToFloat64(tp.metrics.ioErrors.WithLabelValues("write")), 1.0)
+
+	// The fast client is still served.
+	before := count()
+	require.Eventually(t, func() bool {
+		return count() > before
+	}, waitFor, 10*time.Millisecond)
+}
+
+func TestShutdown(t *testing.T) {
+	r := newFakeRemote(t)
+	tp := startProxy(t, Options{Remotes: []string{r.addr()}})
+
+	rc := r.next(t)
+	client := tp.dial(t, 0)
+	addr := tp.ListenAddrs()[0].String()
+
+	tp.stop(t)
+
+	// Clients and remotes are disconnected.
+	client.SetReadDeadline(time.Now().Add(waitFor))
+	_, err := client.Read(make([]byte, 1))
+	assert.Error(t, err)
+	// The proxy never writes to remotes, so notice the close by writing.
+	assert.Eventually(t, func() bool {
+		_, err := rc.Write(identification(0x400001, "ONE"))
+		return err != nil
+	}, waitFor, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(tp.metrics.outboundConnections))
+
+	// No more clients are accepted.
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
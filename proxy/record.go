@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"dump1090-proxy/capture"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recorder writes messages to capture files in the background, so that a
+// slow disk can't hold up the distributor loop.
+type recorder struct {
+	ch  chan capture.Record
+	all bool
+	c   *capture.Recorder
+
+	recorded prometheus.Counter
+	dropped  prometheus.Counter
+}
+
+func newRecorder(opts capture.Options, all bool, m *metrics) (*recorder, error) {
+	c, err := capture.NewRecorder(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorder{
+		ch:       make(chan capture.Record, 1024),
+		all:      all,
+		c:        c,
+		recorded: m.messagesRecorded,
+		dropped:  m.messagesRecordDropped,
+	}, nil
+}
+
+// record queues a message for writing if its remote is being recorded. It
+// never blocks.
+func (r *recorder) record(m message) {
+	if r == nil || !(r.all || m.remote.record) {
+		return
+	}
+
+	select {
+	case r.ch <- capture.Record{Time: m.received, Remote: m.remote.addr, Message: m.raw}:
+	default:
+		r.dropped.Inc()
+	}
+}
+
+// run writes queued records until ctx is cancelled, then writes any still
+// queued and closes the current file. ctx should only be cancelled once
+// nothing more will be recorded.
+func (r *recorder) run(ctx context.Context, logger log.Logger) {
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	// Only log the first of a run of errors, so a full disk doesn't flood
+	// the log.
+	failing := false
+	report := func(err error) {
+		if err == nil {
+			failing = false
+			return
+		}
+		if !failing {
+			level.Error(logger).Log("action", "record", "err", err)
+			failing = true
+		}
+	}
+
+	write := func(rec capture.Record) {
+		name := r.c.Name()
+		err := r.c.Write(rec)
+		if err != nil {
+			r.dropped.Inc()
+		} else {
+			r.recorded.Inc()
+		}
+		report(err)
+
+		if r.c.Name() != name && r.c.Name() != "" {
+			level.Info(logger).Log("action", "record", "file", r.c.Name())
+		}
+	}
+
+	defer func() {
+		report(r.c.Close())
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case rec := <-r.ch:
+					write(rec)
+				default:
+					return
+				}
+			}
+		case <-flush.C:
+			report(r.c.Flush())
+		case rec := <-r.ch:
+			write(rec)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/capture"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readCapture returns the records in every capture file in dir.
+func readCapture(t *testing.T, dir string) []capture.Record {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+
+	var l []capture.Record
+	for _, name := range names {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		r := capture.NewReader(f)
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			l = append(l, rec)
+		}
+		f.Close()
+	}
+	return l
+}
+
+func TestRecorderDrainsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	m := newMetrics(prometheus.NewRegistry(), aircraft.NewTracker(time.Minute))
+	r, err := newRecorder(capture.Options{Dir: dir}, true, m)
+	require.NoError(t, err)
+
+	rem := &remote{addr: "r1"}
+	now := time.Now()
+	const n = 100
+	for i := 0; i < n; i++ {
+		r.record(message{remote: rem, raw: identification(0x400000+uint32(i), "TEST"), received: now})
+	}
+
+	// Everything queued before shutdown is written.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.run(ctx, log.NewNopLogger())
+
+	recs := readCapture(t, dir)
+	require.Len(t, recs, n)
+	assert.Equal(t, identification(0x400000+n-1, "TEST"), recs[n-1].Message)
+	assert.Equal(t, float64(n), testutil.ToFloat64(m.messagesRecorded))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.messagesRecordDropped))
+}
+
+func TestRecordingShutdown(t *testing.T) {
+	dir := t.TempDir()
+	r := newFakeRemote(t)
+	tp := startProxy(t, Options{
+		Remotes: []string{r.addr() + "?record=true"},
+		Record:  capture.Options{Dir: dir},
+	})
+	rc := r.next(t)
+	client := tp.dial(t, 0)
+
+	var frames []byte
+	for i := 0; i < 50; i++ {
+		frames = append(frames, identification(0x400000+uint32(i), "TEST")...)
+	}
+	_, err := rc.Write(frames)
+	require.NoError(t, err)
+	readFrames(t, client, 50)
+
+	// Stopping straight after the last message was distributed, before the
+	// recorder's next flush, still records every message.
+	tp.stop(t)
+	recs := readCapture(t, dir)
+	require.Len(t, recs, 50)
+	assert.Equal(t, r.addr(), recs[49].Remote)
+	assert.Equal(t, identification(0x400031, "TEST"), recs[49].Message)
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"dump1090-proxy/spec"
+)
+
+// remote is an upstream source of beast messages, together with the state of
+// our connection to it.
+type remote struct {
+	addr     string
+	tls      *tls.Config
+	token    string
+	compress string
+	// id is the receiver ID attached to frames from this remote that don't
+	// already carry one. Unless configured, it is derived from the address.
+	id uint64
+
+	// record is set if the remote's frames are written to capture files.
+	record bool
+
+	// Failover group membership.
+	groupName string
+	priority  int
+	group     *group
+
+	mu             sync.Mutex
+	connected      bool
+	connectedSince time.Time
+	lastFrame      time.Time
+	frames         uint64
+	lastError      string
+}
+
+func newRemote(arg string) (*remote, error) {
+	s, err := spec.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(s.Name); err != nil {
+		return nil, fmt.Errorf("%q: %w", s.Name, err)
+	}
+	tlsConfig, err := parseClientTLS(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	token := s.String("token", "")
+	id := parseReceiverID(s.String("id", s.Name))
+	groupName := s.String("group", "")
+	priority := s.Int("priority", 0)
+	record := s.Bool("record", false)
+	compress, err := parseCompression(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+
+	return &remote{
+		addr:      s.Name,
+		tls:       tlsConfig,
+		token:     token,
+		compress:  compress,
+		id:        id,
+		groupName: groupName,
+		priority:  priority,
+		record:    record,
+	}, nil
+}
+
+// parseReceiverID interprets up to 16 hex digits as a receiver ID. Anything
+// else is hashed, so that a memorable name can be used.
+func parseReceiverID(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+
+	if len(s) <= 16 {
+		if id, err := strconv.ParseUint(s, 16, 64); err == nil && id != 0 {
+			return id
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func (r *remote) setConnected(connected bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.connected = connected
+	if connected {
+		r.connectedSince = time.Now()
+	} else {
+		r.connectedSince = time.Time{}
+	}
+}
+
+func (r *remote) setError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastError = err.Error()
+}
+
+func (r *remote) frameReceived(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastFrame = now
+	r.frames++
+}
+
+// remoteStatus is a snapshot of the state of a remote, as reported by the
+// readiness endpoint.
+type remoteStatus struct {
+	Addr           string     `json:"addr"`
+	Connected      bool       `json:"connected"`
+	ConnectedSince *time.Time `json:"connected_since,omitempty"`
+	LastFrame      *time.Time `json:"last_frame,omitempty"`
+	Frames         uint64     `json:"frames"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+func (r *remote) status() remoteStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := remoteStatus{
+		Addr:      r.addr,
+		Connected: r.connected,
+		Frames:    r.frames,
+		LastError: r.lastError,
+	}
+	if !r.connectedSince.IsZero() {
+		t := r.connectedSince
+		s.ConnectedSince = &t
+	}
+	if !r.lastFrame.IsZero() {
+		t := r.lastFrame
+		s.LastFrame = &t
+	}
+
+	return s
+}
+
+// active reports whether the remote is connected and has sent a frame within
+// maxSilence of now.
+func (r *remote) active(now time.Time, maxSilence time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.connected && now.Sub(r.lastFrame) <= maxSilence
+}
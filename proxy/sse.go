@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"dump1090-proxy/spec"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// frameJSON describes a single frame in the Server-Sent Events feed.
+type frameJSON struct {
+	Time       time.Time `json:"time"`
+	Remote     string    `json:"remote"`
+	ReceiverID string    `json:"receiver_id,omitempty"`
+	Type       string    `json:"type"`
+	Timestamp  uint64    `json:"timestamp"`
+	Signal     *float64  `json:"signal,omitempty"`
+	Hex        string    `json:"hex"`
+	DF         *int      `json:"df,omitempty"`
+	ICAO       string    `json:"icao,omitempty"`
+}
+
+func newFrameJSON(m message, now time.Time) frameJSON {
+	f := m.frame
+	j := frameJSON{
+		Time:      now,
+		Remote:    m.remote.addr,
+		Timestamp: f.Timestamp,
+		Hex:       hex.EncodeToString(f.Data),
+	}
+
+	if m.receiverID != 0 {
+		j.ReceiverID = fmt.Sprintf("%016x", m.receiverID)
+	}
+
+	if f.Signal != 0 {
+		rssi := f.RSSI()
+		j.Signal = &rssi
+	}
+
+	switch f.Type {
+	case beast.ModeAC:
+		j.Type = "modeac"
+		return j
+	case beast.ModeSShort:
+		j.Type = "short"
+	case beast.ModeSLong:
+		j.Type = "long"
+	}
+
+	df := modes.DownlinkFormat(f.Data)
+	j.DF = &df
+	if icao, ok := modes.ICAO(f.Data); ok {
+		j.ICAO = fmt.Sprintf("%06x", icao)
+	}
+
+	return j
+}
+
+// frameFeed distributes every frame to Server-Sent Events subscribers.
+// publish is called from the distributor loop, so must never block.
+type frameFeed struct {
+	tracker     *aircraft.Tracker
+	subscribers prometheus.Gauge
+	dropped     prometheus.Counter
+
+	mu   sync.Mutex
+	subs map[*frameSubscriber]struct{}
+}
+
+func newFrameFeed(tracker *aircraft.Tracker, m *metrics) *frameFeed {
+	return &frameFeed{
+		tracker:     tracker,
+		subscribers: m.frameSubscribers,
+		dropped:     m.frameSubscribersDropped,
+		subs:        make(map[*frameSubscriber]struct{}),
+	}
+}
+
+type frameSubscriber struct {
+	ch      chan []byte
+	remotes map[string]bool
+	filter  filter
+}
+
+// newFrameSubscriber creates a subscriber from the query parameters of the
+// request. remote=ADDR,... restricts frames to those remotes; otherwise the
+// parameters are the same as the listener filter options.
+func newFrameSubscriber(q url.Values) (*frameSubscriber, error) {
+	s := spec.New("frames", q)
+
+	sub := &frameSubscriber{
+		ch: make(chan []byte, subscriberBuffer),
+	}
+	for _, r := range s.List("remote") {
+		if sub.remotes == nil {
+			sub.remotes = make(map[string]bool)
+		}
+		sub.remotes[r] = true
+	}
+
+	var err error
+	if sub.filter, err = parseFilter(s); err != nil {
+		return nil, err
+	}
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (f *frameFeed) subscribe(s *frameSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subs[s] = struct{}{}
+	f.subscribers.Set(float64(len(f.subs)))
+}
+
+func (f *frameFeed) unsubscribe(s *frameSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.remove(s)
+}
+
+// remove must be called with the lock held.
+func (f *frameFeed) remove(s *frameSubscriber) {
+	if _, ok := f.subs[s]; !ok {
+		return
+	}
+
+	delete(f.subs, s)
+	close(s.ch)
+	f.subscribers.Set(float64(len(f.subs)))
+}
+
+// close disconnects all the subscribers.
+func (f *frameFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for s := range f.subs {
+		f.remove(s)
+	}
+}
+
+func (f *frameFeed) publish(m message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var b []byte
+	for s := range f.subs {
+		if s.remotes != nil && !s.remotes[m.remote.addr] {
+			continue
+		}
+		if ok, _ := s.filter.accept(m.frame, f.tracker); !ok {
+			continue
+		}
+
+		if b == nil {
+			b = mustMarshal(newFrameJSON(m, time.Now()))
+		}
+
+		select {
+		case s.ch <- b:
+		default:
+			f.dropped.Inc()
+			f.remove(s)
+		}
+	}
+}
+
+func frameFeedHandler(logger log.Logger, f *frameFeed) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		s, err := newFrameSubscriber(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		level.Info(logger).Log("frames", r.RemoteAddr, "action", "connected")
+		defer level.Info(logger).Log("frames", r.RemoteAddr, "action", "disconnected")
+
+		f.subscribe(s)
+		defer f.unsubscribe(s)
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case b, ok := <-s.ch:
+				if !ok {
+					// Dropped for being too slow.
+					return
+				}
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFrameFeed() (*frameFeed, *metrics) {
+	tracker := aircraft.NewTracker(time.Minute)
+	m := newMetrics(prometheus.NewRegistry(), tracker)
+	return newFrameFeed(tracker, m), m
+}
+
+func frameMessage(remoteAddr string, data []byte) message {
+	f := beast.Frame{Type: beast.ModeSLong, Timestamp: 12345, Signal: 0x80, Data: data}
+	if len(data) == 7 {
+		f.Type = beast.ModeSShort
+	}
+	return message{remote: &remote{addr: remoteAddr}, raw: f.Bytes(), frame: f, received: time.Now()}
+}
+
+func TestFrameFeed(t *testing.T) {
+	feed, m := newTestFrameFeed()
+	server := httptest.NewServer(frameFeedHandler(log.NewNopLogger(), feed))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?remote=r1&df=17", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.frameSubscribers) == 1
+	}, waitFor, 10*time.Millisecond)
+
+	// Only the last is from the right remote and has the right DF.
+	feed.publish(frameMessage("r2", modes.EncodeIdentification(0x400001, "A3", "ONE")))
+	feed.publish(frameMessage("r1", modes.EncodeAllCall(0x400002, false)))
+	feed.publish(frameMessage("r1", modes.EncodeIdentification(0x400003, "A3", "THREE")))
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(line, "data: "), line)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got))
+	assert.Equal(t, "r1", got["remote"])
+	assert.Equal(t, "long", got["type"])
+	assert.Equal(t, 17.0, got["df"])
+	assert.Equal(t, "400003", got["icao"])
+	assert.Equal(t, 12345.0, got["timestamp"])
+	assert.NotContains(t, got, "receiver_id")
+
+	// Events are separated by blank lines.
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\n", line)
+
+	// Disconnecting unsubscribes.
+	cancel()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.frameSubscribers) == 0
+	}, waitFor, 10*time.Millisecond)
+}
+
+func TestFrameFeedInvalidQuery(t *testing.T) {
+	feed, _ := newTestFrameFeed()
+	h := frameFeedHandler(log.NewNopLogger(), feed)
+
+	for _, query := range []string{"df=adsb", "min-signal=loud", "allow-icao=xyz", "modeac=sometimes", "colour=red"} {
+		t.Run(query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/frames?"+query, nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Empty(t, feed.subs)
+		})
+	}
+}
+
+func TestFrameFeedDropsSlowSubscriber(t *testing.T) {
+	feed, m := newTestFrameFeed()
+	slow, err := newFrameSubscriber(nil)
+	require.NoError(t, err)
+	feed.subscribe(slow)
+
+	// Nothing reads from the subscriber, but publishing never blocks.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*subscriberBuffer; i++ {
+			feed.publish(frameMessage("r1", modes.EncodeIdentification(0x400001, "A3", "ONE")))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(waitFor):
+		t.Fatal("publish blocked")
+	}
+
+	assert.Empty(t, feed.subs)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.frameSubscribersDropped))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.frameSubscribers))
+
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/spec"
+)
+
+// How a listener treats the MLAT timestamps of frames. Timestamps from
+// different receivers come from unrelated clocks, so are meaningless to MLAT
+// consumers once merged.
+const (
+	// timestampsKeep forwards timestamps unchanged.
+	timestampsKeep = "keep"
+	// timestampsZero sets every timestamp to zero, which MLAT clients treat
+	// as "no timestamp".
+	timestampsZero = "zero"
+	// timestampsProxy replaces timestamps with a 12MHz counter derived from
+	// the proxy's clock at the time the frame arrived.
+	timestampsProxy = "proxy"
+	// timestampsSource forwards only frames from one remote, with their
+	// original timestamps.
+	timestampsSource = "source"
+)
+
+// reasonTimestampSource is the metric label for frames dropped because they
+// did not come from the listener's timestamp source.
+const reasonTimestampSource = "timestamp-source"
+
+func parseTimestamps(s *spec.Spec) (mode string, source string, err error) {
+	mode = s.String("timestamps", timestampsKeep)
+	source = s.String("timestamp-source", "")
+
+	switch mode {
+	case timestampsKeep, timestampsZero, timestampsProxy:
+		if source != "" {
+			return "", "", fmt.Errorf("timestamp-source requires timestamps=%s", timestampsSource)
+		}
+	case timestampsSource:
+		if source == "" {
+			return "", "", fmt.Errorf("timestamps=%s requires timestamp-source", timestampsSource)
+		}
+	default:
+		return "", "", fmt.Errorf("unknown timestamps mode %q", mode)
+	}
+
+	return mode, source, nil
+}
+
+// proxyTimestamp converts a time to a 48-bit 12MHz counter.
+func proxyTimestamp(t time.Time) uint64 {
+	return uint64(t.UnixNano()/1000*12) & (1<<48 - 1)
+}
+
+// render returns the bytes to send to the listener's clients for a message.
+func (l *listener) render(m message) []byte {
+	b := m.raw
+
+	switch l.timestamps {
+	case timestampsZero, timestampsProxy:
+		f := m.frame
+		if l.timestamps == timestampsZero {
+			f.Timestamp = 0
+		} else {
+			f.Timestamp = proxyTimestamp(m.received)
+		}
+		b = f.Bytes()
+	}
+
+	// Every frame gets its own receiver ID frame, zero if the receiver is
+	// unknown, so that clients never apply an earlier frame's ID to it.
+	if l.receiverIDs {
+		b = append(beast.NewReceiverIDFrame(m.receiverID).Bytes(), b...)
+	}
+
+	return b
+}
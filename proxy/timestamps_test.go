@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"dump1090-proxy/spec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := modes.EncodeAllCall(0x400001, false)
+	require.NotContains(t, string(data), "\x1a", "the test expects data without escapes")
+
+	// The frame's timestamp, 0x1a2b3c4d5e1a, needs two escapes.
+	f := beast.Frame{Type: beast.ModeSShort, Timestamp: 0x1a2b3c4d5e1a, Signal: 0x80, Data: data}
+	raw := f.Bytes()
+	m := message{
+		remote:     &remote{addr: "r1"},
+		receiverID: 0x1a00000000000001,
+		// 12MHz ticks since the epoch, 0x1a0000000004, also need an escape.
+		received: time.UnixMicro(0x1a0000000004 / 12),
+		raw:      raw,
+		frame:    f,
+	}
+
+	frame := func(ts string) string {
+		return "\x1a\x32" + ts + "\x80" + string(data)
+	}
+	id := "\x1a\xe3\x1a\x1a\x00\x00\x00\x00\x00\x00\x01"
+
+	tests := []struct {
+		timestamps  string
+		receiverIDs bool
+		want        string
+	}{
+		{timestamps: timestampsKeep, want: frame("\x1a\x1a\x2b\x3c\x4d\x5e\x1a\x1a")},
+		{timestamps: timestampsSource, want: frame("\x1a\x1a\x2b\x3c\x4d\x5e\x1a\x1a")},
+		{timestamps: timestampsZero, want: frame("\x00\x00\x00\x00\x00\x00")},
+		{timestamps: timestampsProxy, want: frame("\x1a\x1a\x00\x00\x00\x00\x04")},
+		{timestamps: timestampsKeep, receiverIDs: true, want: id + frame("\x1a\x1a\x2b\x3c\x4d\x5e\x1a\x1a")},
+		{timestamps: timestampsZero, receiverIDs: true, want: id + frame("\x00\x00\x00\x00\x00\x00")},
+	}
+
+	for _, tt := range tests {
+		l := &listener{timestamps: tt.timestamps, receiverIDs: tt.receiverIDs}
+		assert.Equal(t, []byte(tt.want), l.render(m), "timestamps=%s receiver-ids=%v", tt.timestamps, tt.receiverIDs)
+	}
+
+	// Kept timestamps are sent exactly as received.
+	assert.Equal(t, raw, (&listener{timestamps: timestampsKeep}).render(m))
+
+	// Frames from unknown receivers have an ID of zero.
+	m.receiverID = 0
+	assert.Equal(t, []byte("\x1a\xe3\x00\x00\x00\x00\x00\x00\x00\x00"+string(raw)),
+		(&listener{timestamps: timestampsKeep, receiverIDs: true}).render(m))
+}
+
+func TestParseTimestamps(t *testing.T) {
+	tests := []struct {
+		options    string
+		wantMode   string
+		wantSource string
+		wantErr    string
+	}{
+		{options: "", wantMode: timestampsKeep},
+		{options: "timestamps=keep", wantMode: timestampsKeep},
+		{options: "timestamps=zero", wantMode: timestampsZero},
+		{options: "timestamps=proxy", wantMode: timestampsProxy},
+		{options: "timestamps=source&timestamp-source=r1:30005", wantMode: timestampsSource, wantSource: "r1:30005"},
+		{options: "timestamps=mlat", wantErr: `unknown timestamps mode "mlat"`},
+		{options: "timestamps=source", wantErr: "timestamps=source requires timestamp-source"},
+		{options: "timestamp-source=r1:30005", wantErr: "timestamp-source requires timestamps=source"},
+		{options: "timestamps=zero&timestamp-source=r1:30005", wantErr: "timestamp-source requires timestamps=source"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.options, func(t *testing.T) {
+			s, err := spec.Parse("127.0.0.1:0?" + tt.options)
+			require.NoError(t, err)
+
+			mode, source, err := parseTimestamps(s)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, mode)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+}
+
+func TestTimestampSource(t *testing.T) {
+	all, err := parseTestFilter("")
+	require.NoError(t, err)
+	l := &listener{timestamps: timestampsSource, timestampSource: "r1:30005", filter: all}
+	f := mustParseFrame(t, identification(0x400001, "ONE"))
+	tracker := newTestHub().tracker
+
+	ok, reason := l.accept(message{remote: &remote{addr: "r1:30005"}, frame: f}, tracker)
+	assert.True(t, ok)
+	assert.Equal(t, "", reason)
+
+	ok, reason = l.accept(message{remote: &remote{addr: "r2:30005"}, frame: f}, tracker)
+	assert.False(t, ok)
+	assert.Equal(t, reasonTimestampSource, reason)
+
+	// The source must be one of the remotes.
+	_, err = New(Options{
+		Remotes:    []string{"127.0.0.1:30005"},
+		Listeners:  []string{"127.0.0.1:0?timestamps=source&timestamp-source=127.0.0.1:30006"},
+		Registerer: prometheus.NewRegistry(),
+	})
+	assert.ErrorContains(t, err, "timestamp-source 127.0.0.1:30006 is not a remote")
+}
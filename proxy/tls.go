@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"dump1090-proxy/spec"
+)
+
+// parseServerTLS reads the TLS options of a listener. It returns nil if the
+// listener should accept plain TCP.
+func parseServerTLS(s *spec.Spec) (*tls.Config, error) {
+	certFile := s.String("tls-cert", "")
+	keyFile := s.String("tls-key", "")
+	clientCA := s.String("tls-client-ca", "")
+
+	if certFile == "" && keyFile == "" && clientCA == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("tls-cert and tls-key must both be given")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		if cfg.ClientCAs, err = loadCertPool(clientCA); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// parseClientTLS reads the TLS options of a remote. It returns nil if the
+// remote should be dialled over plain TCP.
+func parseClientTLS(s *spec.Spec) (*tls.Config, error) {
+	enabled := s.Bool("tls", false)
+	caFile := s.String("tls-ca", "")
+	certFile := s.String("tls-cert", "")
+	keyFile := s.String("tls-key", "")
+	serverName := s.String("tls-server-name", "")
+
+	if !enabled {
+		if caFile != "" || certFile != "" || keyFile != "" || serverName != "" {
+			return nil, fmt.Errorf("TLS options given without tls=true")
+		}
+		return nil, nil
+	}
+
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	var err error
+	if caFile != "" {
+		// Trust only this CA, rather than the system roots.
+		if cfg.RootCAs, err = loadCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("tls-cert and tls-key must both be given")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dump1090-proxy/spec"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI is a throwaway CA, with a certificate for a server on 127.0.0.1 and
+// one for a client, written to PEM files.
+type testPKI struct {
+	ca                    string
+	serverCert, serverKey string
+	clientCert, clientKey string
+	// other is a CA that signed nothing.
+	other string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	serial := int64(0)
+
+	issue := func(name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.Subject = pkix.Name{CommonName: name}
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+
+		writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+
+		return cert, key
+	}
+
+	ca := &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caCert, caKey := issue("ca", ca, nil, nil)
+	issue("server", &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, caCert, caKey)
+	issue("client", &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, caCert, caKey)
+	issue("other", &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+
+	return &testPKI{
+		ca:         filepath.Join(dir, "ca.pem"),
+		serverCert: filepath.Join(dir, "server.pem"),
+		serverKey:  filepath.Join(dir, "server-key.pem"),
+		clientCert: filepath.Join(dir, "client.pem"),
+		clientKey:  filepath.Join(dir, "client-key.pem"),
+		other:      filepath.Join(dir, "other.pem"),
+	}
+}
+
+func writePEM(t *testing.T, name string, blockType string, der []byte) {
+	require.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+func TestParseServerTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	s, err := spec.Parse("127.0.0.1:0")
+	require.NoError(t, err)
+	cfg, err := parseServerTLS(s)
+	require.NoError(t, err)
+	assert.Nil(t, cfg, "plain TCP without TLS options")
+
+	s, err = spec.Parse("127.0.0.1:0?tls-cert=" + pki.serverCert + "&tls-key=" + pki.serverKey)
+	require.NoError(t, err)
+	cfg, err = parseServerTLS(s)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Certificates, 1)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	s, err = spec.Parse("127.0.0.1:0?tls-cert=" + pki.serverCert + "&tls-key=" + pki.serverKey + "&tls-client-ca=" + pki.ca)
+	require.NoError(t, err)
+	cfg, err = parseServerTLS(s)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	assert.NotNil(t, cfg.ClientCAs)
+
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{name: "cert without key", options: "tls-cert=" + pki.serverCert, wantErr: "tls-cert and tls-key must both be given"},
+		{name: "key without cert", options: "tls-key=" + pki.serverKey, wantErr: "tls-cert and tls-key must both be given"},
+		{name: "client CA alone", options: "tls-client-ca=" + pki.ca, wantErr: "tls-cert and tls-key must both be given"},
+		{name: "missing cert", options: "tls-cert=/nonexistent.pem&tls-key=" + pki.serverKey, wantErr: "no such file"},
+		{name: "mismatched key", options: "tls-cert=" + pki.serverCert + "&tls-key=" + pki.clientKey, wantErr: "private key does not match"},
+		{name: "bad cert", options: "tls-cert=" + notPEM + "&tls-key=" + pki.serverKey, wantErr: "failed to find any PEM data"},
+		{name: "missing client CA", options: "tls-cert=" + pki.serverCert + "&tls-key=" + pki.serverKey + "&tls-client-ca=/nonexistent.pem", wantErr: "no such file"},
+		{name: "bad client CA", options: "tls-cert=" + pki.serverCert + "&tls-key=" + pki.serverKey + "&tls-client-ca=" + notPEM, wantErr: "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := spec.Parse("127.0.0.1:0?" + tt.options)
+			require.NoError(t, err)
+			_, err = parseServerTLS(s)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseClientTLS(t *testing.T) {
+	pki := newTestPKI(t)
+
+	s, err := spec.Parse("receiver.example.com:30005")
+	require.NoError(t, err)
+	cfg, err := parseClientTLS(s)
+	require.NoError(t, err)
+	assert.Nil(t, cfg, "plain TCP without tls=true")
+
+	// The server name defaults to the remote's host.
+	s, err = spec.Parse("receiver.example.com:30005?tls=true")
+	require.NoError(t, err)
+	cfg, err = parseClientTLS(s)
+	require.NoError(t, err)
+	assert.Equal(t, "receiver.example.com", cfg.ServerName)
+	assert.Nil(t, cfg.RootCAs, "system roots by default")
+	assert.Empty(t, cfg.Certificates)
+
+	s, err = spec.Parse("10.0.0.1:30005?tls=true&tls-server-name=receiver.example.com&tls-ca=" + pki.ca +
+		"&tls-cert=" + pki.clientCert + "&tls-key=" + pki.clientKey)
+	require.NoError(t, err)
+	cfg, err = parseClientTLS(s)
+	require.NoError(t, err)
+	assert.Equal(t, "receiver.example.com", cfg.ServerName)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{name: "options without tls", options: "tls-ca=" + pki.ca, wantErr: "TLS options given without tls=true"},
+		{name: "disabled", options: "tls=false&tls-server-name=x", wantErr: "TLS options given without tls=true"},
+		{name: "cert without key", options: "tls=true&tls-cert=" + pki.clientCert, wantErr: "tls-cert and tls-key must both be given"},
+		{name: "missing CA", options: "tls=true&tls-ca=/nonexistent.pem", wantErr: "no such file"},
+		{name: "missing key", options: "tls=true&tls-cert=" + pki.clientCert + "&tls-key=/nonexistent.pem", wantErr: "no such file"},
+		{name: "mismatched key", options: "tls=true&tls-cert=" + pki.clientCert + "&tls-key=" + pki.serverKey, wantErr: "private key does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := spec.Parse("127.0.0.1:30005?" + tt.options)
+			require.NoError(t, err)
+			_, err = parseClientTLS(s)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTLSLink(t *testing.T) {
+	pki := newTestPKI(t)
+
+	// A receiver feeds an upstream proxy, which serves it over mutual TLS.
+	r := newFakeRemote(t)
+	upstream := startProxy(t, Options{
+		Remotes:   []string{r.addr()},
+		Listeners: []string{"127.0.0.1:0?tls-cert=" + pki.serverCert + "&tls-key=" + pki.serverKey + "&tls-client-ca=" + pki.ca},
+	})
+	rc := r.next(t)
+	addr := upstream.ListenAddrs()[0].String()
+
+	rejected := func() float64 {
+		return testutil.ToFloat64(upstream.metrics.connectionsRejected.With(prometheus.Labels{
+			"listener": upstream.listeners[0].addr,
+			"reason":   rejectHandshake,
+		}))
+	}
+
+	// Without a client certificate, or trusting the wrong CA, the handshake
+	// fails.
+	startProxy(t, Options{Remotes: []string{addr + "?tls=true&tls-ca=" + pki.ca}})
+	require.Eventually(t, func() bool { return rejected() >= 1 }, waitFor, 10*time.Millisecond)
+	startProxy(t, Options{Remotes: []string{addr + "?tls=true&tls-ca=" + pki.other + "&tls-cert=" + pki.clientCert + "&tls-key=" + pki.clientKey}})
+	require.Eventually(t, func() bool { return rejected() >= 2 }, waitFor, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(upstream.metrics.inboundConnections))
+
+	downstream := startProxy(t, Options{
+		Remotes: []string{addr + "?tls=true&tls-ca=" + pki.ca + "&tls-cert=" + pki.clientCert + "&tls-key=" + pki.clientKey},
+	})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(upstream.metrics.inboundConnections) == 1
+	}, waitFor, 10*time.Millisecond)
+	client := downstream.dial(t, 0)
+
+	f1 := identification(0x400001, "ONE")
+	_, err := rc.Write(f1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{string(f1)}, readFrames(t, client, 1))
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"dump1090-proxy/aircraft"
+)
+
+// aircraftJSON is an entry in aircraft.json, in the format written by
+// dump1090-fa and readsb, and understood by tar1090 and SkyAware.
+type aircraftJSON struct {
+	Hex       string      `json:"hex"`
+	Flight    string      `json:"flight,omitempty"`
+	AltBaro   interface{} `json:"alt_baro,omitempty"`
+	GS        *float64    `json:"gs,omitempty"`
+	Track     *float64    `json:"track,omitempty"`
+	BaroRate  *int        `json:"baro_rate,omitempty"`
+	Squawk    string      `json:"squawk,omitempty"`
+	Category  string      `json:"category,omitempty"`
+	Lat       *float64    `json:"lat,omitempty"`
+	Lon       *float64    `json:"lon,omitempty"`
+	SeenPos   *float64    `json:"seen_pos,omitempty"`
+	Seen      float64     `json:"seen"`
+	Messages  int         `json:"messages"`
+	RSSI      float64     `json:"rssi"`
+	Receivers []string    `json:"receivers,omitempty"`
+}
+
+type aircraftFileJSON struct {
+	Now      float64        `json:"now"`
+	Messages uint64         `json:"messages"`
+	Aircraft []aircraftJSON `json:"aircraft"`
+}
+
+type receiverJSON struct {
+	Version string   `json:"version"`
+	Refresh int      `json:"refresh"`
+	History int      `json:"history"`
+	Lat     *float64 `json:"lat,omitempty"`
+	Lon     *float64 `json:"lon,omitempty"`
+}
+
+func newAircraftJSON(a aircraft.Aircraft, now time.Time) aircraftJSON {
+	j := aircraftJSON{
+		Hex:       fmt.Sprintf("%06x", a.ICAO),
+		Flight:    a.Callsign,
+		Squawk:    a.Squawk,
+		Category:  a.Category,
+		Seen:      secondsSince(now, a.LastSeen),
+		Messages:  a.Messages,
+		RSSI:      math.Max(a.RSSI, -49.5),
+		Receivers: a.Remotes,
+	}
+
+	if a.HasOnGround && a.OnGround {
+		j.AltBaro = "ground"
+	} else if a.HasAltitude {
+		j.AltBaro = a.Altitude
+	}
+	if a.HasVelocity {
+		j.GS, j.Track = &a.GroundSpeed, &a.Track
+	}
+	if a.HasVerticalRate {
+		j.BaroRate = &a.VerticalRate
+	}
+	if a.HasPosition {
+		seenPos := secondsSince(now, a.PositionTime)
+		j.Lat, j.Lon, j.SeenPos = &a.Lat, &a.Lon, &seenPos
+	}
+
+	return j
+}
+
+func secondsSince(now time.Time, t time.Time) float64 {
+	return math.Round(now.Sub(t).Seconds()*10) / 10
+}
+
+func aircraftHandler(tracker *aircraft.Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		all := tracker.All()
+
+		file := aircraftFileJSON{
+			Now:      float64(now.UnixNano()) / 1e9,
+			Messages: tracker.Messages(),
+			Aircraft: make([]aircraftJSON, 0, len(all)),
+		}
+		for _, a := range all {
+			file.Aircraft = append(file.Aircraft, newAircraftJSON(a, now))
+		}
+
+		writeJSON(w, http.StatusOK, file)
+	})
+}
+
+// receiverHandler serves receiver.json. lat and lon may be nil if the
+// location is unknown.
+func receiverHandler(lat, lon *float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := receiverJSON{
+			Version: "dump1090-proxy",
+			Refresh: 1000,
+		}
+		if lat != nil && lon != nil {
+			rec.Lat, rec.Lon = lat, lon
+		}
+
+		writeJSON(w, http.StatusOK, rec)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	// Too late to report an error to the client.
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// get serves a request, and decodes the JSON response.
+func get(t *testing.T, h http.Handler, v interface{}) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+}
+
+func TestAircraftHandler(t *testing.T) {
+	const icao = 0x40621d
+	tracker := aircraft.NewTracker(time.Minute)
+	now := time.Now()
+	update := func(remote string, data []byte) {
+		tracker.Update(remote, beast.Frame{Type: beast.ModeSLong, Signal: 0x80, Data: data}, now)
+	}
+	update("r1", modes.EncodeIdentification(icao, "A3", "EZY12A"))
+	update("r2", modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, false)))
+	update("r2", modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, true)))
+	update("r1", modes.EncodeVelocity(icao, 450, 90, -640))
+	update("r1", modes.EncodeIdentification(0x400001, "A3", "TWO"))
+
+	var file struct {
+		Now      float64                  `json:"now"`
+		Messages uint64                   `json:"messages"`
+		Aircraft []map[string]interface{} `json:"aircraft"`
+	}
+	get(t, aircraftHandler(tracker), &file)
+
+	assert.InDelta(t, float64(time.Now().UnixNano())/1e9, file.Now, 5)
+	assert.Equal(t, uint64(5), file.Messages)
+	require.Len(t, file.Aircraft, 2)
+
+	var a, b map[string]interface{}
+	for _, ac := range file.Aircraft {
+		switch ac["hex"] {
+		case "40621d":
+			a = ac
+		case "400001":
+			b = ac
+		}
+	}
+	require.NotNil(t, a)
+	require.NotNil(t, b)
+
+	assert.Equal(t, "EZY12A", a["flight"])
+	assert.Equal(t, "A3", a["category"])
+	assert.Equal(t, 38000.0, a["alt_baro"])
+	assert.InDelta(t, 52.2572, a["lat"], 1e-4)
+	assert.InDelta(t, 3.91937, a["lon"], 1e-4)
+	assert.InDelta(t, 450, a["gs"], 1)
+	assert.InDelta(t, 90, a["track"], 1)
+	assert.Equal(t, -640.0, a["baro_rate"])
+	assert.Contains(t, a, "seen_pos")
+	assert.Equal(t, 4.0, a["messages"])
+	assert.InDelta(t, -6, a["rssi"], 0.1)
+	assert.Equal(t, []interface{}{"r1", "r2"}, a["receivers"])
+
+	// Fields that aren't known are left out.
+	for _, field := range []string{"alt_baro", "gs", "track", "baro_rate", "squawk", "lat", "lon", "seen_pos"} {
+		assert.NotContains(t, b, field)
+	}
+	assert.Equal(t, "TWO", b["flight"])
+	assert.Equal(t, 1.0, b["messages"])
+	assert.Equal(t, []interface{}{"r1"}, b["receivers"])
+}
+
+func TestAircraftHandlerEmpty(t *testing.T) {
+	var file map[string]interface{}
+	get(t, aircraftHandler(aircraft.NewTracker(time.Minute)), &file)
+
+	// An empty list rather than null, which some clients can't handle.
+	assert.Equal(t, []interface{}{}, file["aircraft"])
+	assert.Equal(t, 0.0, file["messages"])
+}
+
+func TestNewAircraftJSON(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		a    aircraft.Aircraft
+		want aircraftJSON
+	}{
+		{
+			name: "minimal",
+			a:    aircraft.Aircraft{ICAO: 0xabc, LastSeen: now.Add(-1234 * time.Millisecond), Messages: 1, RSSI: -20},
+			want: aircraftJSON{Hex: "000abc", Seen: 1.2, Messages: 1, RSSI: -20},
+		},
+		{
+			name: "on ground",
+			a:    aircraft.Aircraft{ICAO: 0xabc, LastSeen: now, HasAltitude: true, Altitude: 25, HasOnGround: true, OnGround: true, RSSI: -20},
+			want: aircraftJSON{Hex: "000abc", AltBaro: "ground", RSSI: -20},
+		},
+		{
+			name: "airborne",
+			a:    aircraft.Aircraft{ICAO: 0xabc, LastSeen: now, HasAltitude: true, Altitude: 2500, HasOnGround: true, RSSI: -20},
+			want: aircraftJSON{Hex: "000abc", AltBaro: 2500, RSSI: -20},
+		},
+		{
+			// Signal levels of zero give -Inf, which JSON can't represent.
+			name: "unmeasured signal",
+			a:    aircraft.Aircraft{ICAO: 0xabc, LastSeen: now, RSSI: math.Inf(-1)},
+			want: aircraftJSON{Hex: "000abc", RSSI: -49.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newAircraftJSON(tt.a, now))
+			_, err := json.Marshal(newAircraftJSON(tt.a, now))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestReceiverHandler(t *testing.T) {
+	var rec map[string]interface{}
+	get(t, receiverHandler(nil, nil), &rec)
+	assert.Equal(t, map[string]interface{}{"version": "dump1090-proxy", "refresh": 1000.0, "history": 0.0}, rec)
+
+	lat, lon := 51.5, -0.1
+	rec = nil
+	get(t, receiverHandler(&lat, &lon), &rec)
+	assert.Equal(t, 51.5, rec["lat"])
+	assert.Equal(t, -0.1, rec["lon"])
+}
@@ -0,0 +1,443 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subscriberBuffer is the number of updates that may be queued for a
+// subscriber before it is considered too slow, and dropped.
+const subscriberBuffer = 256
+
+// volatileFields change with every message, so don't on their own justify
+// sending an update.
+var volatileFields = map[string]bool{
+	"seen":     true,
+	"seen_pos": true,
+	"messages": true,
+	"rssi":     true,
+}
+
+type event struct {
+	Type     string                 `json:"type"`
+	Hex      string                 `json:"hex,omitempty"`
+	Remote   string                 `json:"remote,omitempty"`
+	Frame    string                 `json:"frame,omitempty"`
+	Aircraft map[string]interface{} `json:"aircraft,omitempty"`
+}
+
+// hub distributes live updates to websocket subscribers. publish and expire
+// are called from the distributor loop, so must never block.
+type hub struct {
+	tracker     *aircraft.Tracker
+	subscribers prometheus.Gauge
+	dropped     prometheus.Counter
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+	// last is the most recently published state of each aircraft, so that
+	// only changes need be sent.
+	last map[uint32]map[string]interface{}
+}
+
+func newHub(tracker *aircraft.Tracker, m *metrics) *hub {
+	return &hub{
+		tracker:     tracker,
+		subscribers: m.websocketSubscribers,
+		dropped:     m.websocketDropped,
+		subs:        make(map[*subscriber]struct{}),
+		last:        make(map[uint32]map[string]interface{}),
+	}
+}
+
+type bbox struct {
+	south, west, north, east float64
+}
+
+func (b bbox) contains(lat, lon float64) bool {
+	if lat < b.south || lat > b.north {
+		return false
+	}
+	if b.west <= b.east {
+		return lon >= b.west && lon <= b.east
+	}
+	// Crosses the antimeridian.
+	return lon >= b.west || lon <= b.east
+}
+
+type subscriber struct {
+	ch   chan []byte
+	icao map[uint32]bool
+	bbox *bbox
+	raw  bool
+
+	// sent holds the aircraft the subscriber has been sent in full, and
+	// which it still wants, so that it need only be sent their changes.
+	sent map[uint32]bool
+}
+
+// newSubscriber creates a subscriber from the query parameters of the
+// request: icao=HEX,... and bbox=SOUTH,WEST,NORTH,EAST restrict the aircraft
+// reported, and raw=true adds every frame, hex encoded.
+func newSubscriber(q url.Values) (*subscriber, error) {
+	s := &subscriber{sent: make(map[uint32]bool)}
+
+	var err error
+	if s.icao, err = parseICAOs(splitList(q["icao"])); err != nil {
+		return nil, err
+	}
+
+	if v := q.Get("bbox"); v != "" {
+		f := strings.Split(v, ",")
+		if len(f) != 4 {
+			return nil, fmt.Errorf("bbox must be south,west,north,east")
+		}
+		var n [4]float64
+		for i := range f {
+			if n[i], err = strconv.ParseFloat(f[i], 64); err != nil {
+				return nil, fmt.Errorf("invalid bbox %q", v)
+			}
+		}
+		s.bbox = &bbox{south: n[0], west: n[1], north: n[2], east: n[3]}
+	}
+
+	if v := q.Get("raw"); v != "" {
+		if s.raw, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid raw %q", v)
+		}
+	}
+
+	return s, nil
+}
+
+func splitList(values []string) []string {
+	var l []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				l = append(l, item)
+			}
+		}
+	}
+	return l
+}
+
+// wants reports whether the subscriber is interested in an aircraft. hasICAO
+// is false for frames that carry no address.
+func (s *subscriber) wants(icao uint32, hasICAO bool, a aircraft.Aircraft) bool {
+	if s.icao != nil && (!hasICAO || !s.icao[icao]) {
+		return false
+	}
+	if s.bbox != nil && (!a.HasPosition || !s.bbox.contains(a.Lat, a.Lon)) {
+		return false
+	}
+	return true
+}
+
+func (h *hub) subscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Start the subscriber off with everything we know, leaving room for
+	// that on top of the usual buffer.
+	all := h.tracker.All()
+	s.ch = make(chan []byte, subscriberBuffer+len(all))
+
+	h.subs[s] = struct{}{}
+	h.subscribers.Set(float64(len(h.subs)))
+
+	now := time.Now()
+	for _, a := range all {
+		if s.wants(a.ICAO, true, a) {
+			s.sent[a.ICAO] = true
+			if !h.send(s, mustMarshal(event{Type: "aircraft", Aircraft: aircraftFields(a, now)})) {
+				return
+			}
+		}
+	}
+}
+
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(s)
+}
+
+// remove must be called with the lock held.
+func (h *hub) remove(s *subscriber) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+
+	delete(h.subs, s)
+	close(s.ch)
+	h.subscribers.Set(float64(len(h.subs)))
+
+	if len(h.subs) == 0 {
+		h.last = make(map[uint32]map[string]interface{})
+	}
+}
+
+// close disconnects all the subscribers.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		h.remove(s)
+	}
+}
+
+// send queues an update, dropping the subscriber if it has fallen too far
+// behind. It returns false if the subscriber has been dropped, after which
+// nothing more may be sent to it. It must be called with the lock held.
+func (h *hub) send(s *subscriber, b []byte) bool {
+	select {
+	case s.ch <- b:
+		return true
+	default:
+		h.dropped.Inc()
+		h.remove(s)
+		return false
+	}
+}
+
+// publish sends the changes resulting from a message to the subscribers.
+func (h *hub) publish(m message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+
+	var (
+		icao    uint32
+		hasICAO bool
+		a       aircraft.Aircraft
+		known   bool
+		update  []byte
+		full    []byte
+		raw     []byte
+	)
+
+	if m.frame.Type != beast.ModeAC {
+		icao, hasICAO = modes.ICAO(m.frame.Data)
+	}
+	if hasICAO {
+		a, known = h.tracker.Get(icao)
+	}
+	if known {
+		if delta := h.delta(a); delta != nil {
+			update = mustMarshal(event{Type: "aircraft", Aircraft: delta})
+		}
+	}
+
+	for s := range h.subs {
+		if !s.wants(icao, hasICAO, a) {
+			// It may have left the subscriber's box, in which case the
+			// subscriber should forget it, as if it had expired. It may
+			// have changed by the time it returns.
+			if s.sent[icao] {
+				delete(s.sent, icao)
+				h.send(s, expiredEvent(icao))
+			}
+			continue
+		}
+
+		switch {
+		case known && !s.sent[icao]:
+			// The subscriber has not heard of the aircraft (e.g. because it
+			// has just flown into its box), so changes alone aren't enough.
+			if full == nil {
+				full = mustMarshal(event{Type: "aircraft", Aircraft: aircraftFields(a, time.Now())})
+			}
+			s.sent[icao] = true
+			if !h.send(s, full) {
+				continue
+			}
+		case update != nil:
+			if !h.send(s, update) {
+				continue
+			}
+		}
+
+		if s.raw {
+			if raw == nil {
+				raw = mustMarshal(event{Type: "frame", Remote: m.remote.addr, Frame: hex.EncodeToString(m.raw)})
+			}
+			h.send(s, raw)
+		}
+	}
+}
+
+// delta returns the fields of the aircraft that have changed since it was
+// last published, or nil if nothing significant has changed.
+func (h *hub) delta(a aircraft.Aircraft) map[string]interface{} {
+	fields := aircraftFields(a, time.Now())
+	last := h.last[a.ICAO]
+	h.last[a.ICAO] = fields
+
+	delta := make(map[string]interface{})
+	changed := false
+	for k, v := range fields {
+		if old, ok := last[k]; !ok || old != v {
+			delta[k] = v
+			changed = changed || !volatileFields[k]
+		}
+	}
+	for k := range last {
+		if _, ok := fields[k]; !ok {
+			delta[k] = nil
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	delta["hex"] = fields["hex"]
+	for k := range volatileFields {
+		if v, ok := fields[k]; ok {
+			delta[k] = v
+		}
+	}
+
+	return delta
+}
+
+// expire tells subscribers about aircraft that are no longer tracked.
+func (h *hub) expire(icaos []uint32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, icao := range icaos {
+		delete(h.last, icao)
+
+		if len(h.subs) == 0 {
+			continue
+		}
+
+		b := expiredEvent(icao)
+		for s := range h.subs {
+			// Only subscribers which were told about the aircraft, and for
+			// which it was last within their filters, need to forget it.
+			if s.sent[icao] {
+				delete(s.sent, icao)
+				h.send(s, b)
+			}
+		}
+	}
+}
+
+// expiredEvent tells a subscriber to forget an aircraft.
+func expiredEvent(icao uint32) []byte {
+	return mustMarshal(event{Type: "expired", Hex: fmt.Sprintf("%06x", icao)})
+}
+
+// aircraftFields returns the aircraft as it appears in aircraft.json, as a
+// map so that it can be compared field by field. Every value is comparable,
+// so the list of receivers is flattened.
+func aircraftFields(a aircraft.Aircraft, now time.Time) map[string]interface{} {
+	j := newAircraftJSON(a, now)
+	fields := map[string]interface{}{
+		"hex":      j.Hex,
+		"seen":     j.Seen,
+		"messages": j.Messages,
+		"rssi":     j.RSSI,
+	}
+
+	// As in aircraft.json, fields that are unknown are left out.
+	set := func(key string, v interface{}, ok bool) {
+		if ok {
+			fields[key] = v
+		}
+	}
+	set("flight", j.Flight, j.Flight != "")
+	set("alt_baro", j.AltBaro, j.AltBaro != nil)
+	set("squawk", j.Squawk, j.Squawk != "")
+	set("category", j.Category, j.Category != "")
+	set("receivers", strings.Join(j.Receivers, ","), len(j.Receivers) > 0)
+	if j.GS != nil {
+		fields["gs"], fields["track"] = *j.GS, *j.Track
+	}
+	if j.BaroRate != nil {
+		fields["baro_rate"] = *j.BaroRate
+	}
+	if j.Lat != nil {
+		fields["lat"], fields["lon"], fields["seen_pos"] = *j.Lat, *j.Lon, *j.SeenPos
+	}
+
+	return fields
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func websocketHandler(logger log.Logger, h *hub) http.Handler {
+	upgrader := websocket.Upgrader{
+		// Maps are usually served from a different origin.
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := newSubscriber(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied to the client.
+			return
+		}
+		defer conn.Close()
+
+		level.Info(logger).Log("websocket", r.RemoteAddr, "action", "connected")
+		defer level.Info(logger).Log("websocket", r.RemoteAddr, "action", "disconnected")
+
+		h.subscribe(s)
+
+		// We don't expect anything from the client, but must read to notice
+		// it closing.
+		go func() {
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					h.unsubscribe(s)
+					return
+				}
+			}
+		}()
+
+		for b := range s.ch {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				h.unsubscribe(s)
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHub is a hub fed directly, as the distributor loop would.
+type testHub struct {
+	*hub
+	tracker *aircraft.Tracker
+	metrics *metrics
+	remote  *remote
+}
+
+func newTestHub() *testHub {
+	tracker := aircraft.NewTracker(time.Minute)
+	m := newMetrics(prometheus.NewRegistry(), tracker)
+	return &testHub{hub: newHub(tracker, m), tracker: tracker, metrics: m, remote: &remote{addr: "r1"}}
+}
+
+func (th *testHub) feed(data []byte) {
+	f := beast.Frame{Type: beast.ModeSLong, Signal: 0x80, Data: data}
+	th.tracker.Update(th.remote.addr, f, time.Now())
+	th.publish(message{remote: th.remote, raw: f.Bytes(), frame: f})
+}
+
+func subscribe(t *testing.T, th *testHub, query string) *subscriber {
+	q, err := url.ParseQuery(query)
+	require.NoError(t, err)
+	s, err := newSubscriber(q)
+	require.NoError(t, err)
+	th.subscribe(s)
+	return s
+}
+
+// events returns the events queued for a subscriber.
+func events(t *testing.T, s *subscriber) []event {
+	var l []event
+	for {
+		select {
+		case b, ok := <-s.ch:
+			if !ok {
+				return l
+			}
+			var e event
+			require.NoError(t, json.Unmarshal(b, &e))
+			l = append(l, e)
+		default:
+			return l
+		}
+	}
+}
+
+func TestHubDropsSlowRawSubscriber(t *testing.T) {
+	th := newTestHub()
+	s := subscribe(t, th, "raw=true")
+
+	// Every message changes the callsign, so gives both an update and a raw
+	// frame, filling the subscriber's buffer part way through a message.
+	assert.NotPanics(t, func() {
+		for i := 0; i < 1000; i++ {
+			th.feed(modes.EncodeIdentification(0x400001, "A3", fmt.Sprintf("T%d", i)))
+		}
+	})
+
+	assert.Empty(t, th.subs)
+	assert.Equal(t, 1.0, testutil.ToFloat64(th.metrics.websocketDropped))
+	assert.Len(t, events(t, s), subscriberBuffer)
+	_, ok := <-s.ch
+	assert.False(t, ok, "channel should be closed")
+}
+
+func TestHubBoundingBox(t *testing.T) {
+	const icao = 0x40621d
+	th := newTestHub()
+	inside := subscribe(t, th, "bbox=51,3,53,5")
+	elsewhere := subscribe(t, th, "bbox=40,-80,41,-70")
+
+	// Identified before its position is known, so outside every box.
+	th.feed(modes.EncodeIdentification(icao, "A3", "EZY12A"))
+	assert.Empty(t, events(t, inside))
+
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, false)))
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, true)))
+
+	// Having entered the box, the whole aircraft is sent, including the
+	// callsign it had before.
+	l := events(t, inside)
+	require.Len(t, l, 1)
+	assert.Equal(t, "aircraft", l[0].Type)
+	assert.Equal(t, "EZY12A", l[0].Aircraft["flight"])
+	assert.Equal(t, "40621d", l[0].Aircraft["hex"])
+	assert.Contains(t, l[0].Aircraft, "lat")
+
+	// After which only changes are sent.
+	th.feed(modes.EncodeVelocity(icao, 450, 90, -640))
+	l = events(t, inside)
+	require.Len(t, l, 1)
+	assert.NotContains(t, l[0].Aircraft, "flight")
+	assert.Contains(t, l[0].Aircraft, "gs")
+
+	th.expire([]uint32{icao})
+	l = events(t, inside)
+	require.Len(t, l, 1)
+	assert.Equal(t, event{Type: "expired", Hex: "40621d"}, l[0])
+
+	// The aircraft was never in the other box.
+	assert.Empty(t, events(t, elsewhere))
+}
+
+func TestHubExpiredFilteredByICAO(t *testing.T) {
+	th := newTestHub()
+	th.feed(modes.EncodeIdentification(0x400001, "A3", "ONE"))
+	th.feed(modes.EncodeIdentification(0x400002, "A3", "TWO"))
+
+	s := subscribe(t, th, "icao=400002")
+	l := events(t, s)
+	require.Len(t, l, 1)
+	assert.Equal(t, "TWO", l[0].Aircraft["flight"])
+
+	th.expire([]uint32{0x400001, 0x400002})
+	assert.Equal(t, []event{{Type: "expired", Hex: "400002"}}, events(t, s))
+}
+
+func TestHubAircraftLeavesBoundingBox(t *testing.T) {
+	const icao = 0x40621d
+	th := newTestHub()
+	s := subscribe(t, th, "bbox=51,3,53,5")
+
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, false)))
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, true)))
+	l := events(t, s)
+	require.Len(t, l, 1)
+	assert.Equal(t, "aircraft", l[0].Type)
+
+	// Flying east out of the box, the subscriber is told to forget it, just
+	// once.
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 5.5, false)))
+	th.feed(modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 5.5, true)))
+	th.feed(modes.EncodeVelocity(icao, 450, 90, -640))
+	assert.Equal(t, []event{{Type: "expired", Hex: "40621d"}}, events(t, s))
+
+	// Nor is it told again when the aircraft expires.
+	th.expire([]uint32{icao})
+	assert.Empty(t, events(t, s))
+}
+
+func TestAircraftFieldsMatchAircraftJSON(t *testing.T) {
+	now := time.Now()
+	for _, a := range []aircraft.Aircraft{
+		{ICAO: 0x400001, LastSeen: now, Messages: 1, RSSI: -60},
+		{
+			ICAO: 0x40621d, Callsign: "EZY12A", Squawk: "7000", Category: "A3",
+			HasAltitude: true, Altitude: 38000,
+			HasVelocity: true, GroundSpeed: 450, Track: 90,
+			HasVerticalRate: true, VerticalRate: -640,
+			HasPosition: true, Lat: 52.2572, Lon: 3.91937, PositionTime: now.Add(-time.Second),
+			LastSeen: now, Messages: 10, RSSI: -6, Remotes: []string{"r1", "r2"},
+		},
+		{ICAO: 0x400002, HasAltitude: true, Altitude: 0, HasOnGround: true, OnGround: true, LastSeen: now},
+	} {
+		var want map[string]interface{}
+		require.NoError(t, json.Unmarshal(mustMarshal(newAircraftJSON(a, now)), &want))
+		if r, ok := want["receivers"]; ok {
+			assert.Len(t, r, len(a.Remotes))
+			want["receivers"] = strings.Join(a.Remotes, ",")
+		}
+
+		var got map[string]interface{}
+		require.NoError(t, json.Unmarshal(mustMarshal(aircraftFields(a, now)), &got))
+		assert.Equal(t, want, got)
+	}
+}
@@ -0,0 +1,166 @@
+package sbs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"dump1090-proxy/aircraft"
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+)
+
+const (
+	// beastExpiry is how long the state used to decode positions is kept
+	// after an aircraft's last message.
+	beastExpiry = time.Minute
+	// expireInterval is how often that state is pruned.
+	expireInterval = 10 * time.Second
+)
+
+// BeastReader decodes a beast stream into the same messages that dump1090
+// would write in SBS (BaseStation) format.
+type BeastReader struct {
+	r       *bufio.Reader
+	tracker *aircraft.Tracker
+	// now returns the time to give each message, which SBS sets to when the
+	// message was decoded.
+	now        func() time.Time
+	nextExpiry time.Time
+}
+
+func NewBeastReader(r io.Reader) *BeastReader {
+	return &BeastReader{
+		r:       bufio.NewReader(r),
+		tracker: aircraft.NewTracker(beastExpiry),
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Read returns the next message, skipping frames which have no SBS
+// equivalent or which can't be trusted.
+func (r *BeastReader) Read() (Message, error) {
+	for {
+		b, err := beast.ReadMessage(r.r)
+		if _, ok := err.(beast.InvalidMessage); ok {
+			// Out of step with the stream; ReadMessage will have consumed at
+			// least one byte, so try again with the rest.
+			continue
+		}
+		if err != nil {
+			return Message{}, err
+		}
+		if b == nil {
+			continue
+		}
+
+		f, err := beast.ParseFrame(b)
+		if err != nil {
+			continue
+		}
+
+		if m, ok := r.decode(f); ok {
+			return m, nil
+		}
+	}
+}
+
+func (r *BeastReader) decode(f beast.Frame) (Message, bool) {
+	now := r.now()
+	if now.After(r.nextExpiry) {
+		r.tracker.Expire(now)
+		r.nextExpiry = now.Add(expireInterval)
+	}
+
+	if f.Type != beast.ModeSShort && f.Type != beast.ModeSLong {
+		return Message{}, false
+	}
+
+	mm, ok := modes.Decode(f.Data)
+	if !ok {
+		return Message{}, false
+	}
+
+	// The tracker discards damaged messages (and Address/Parity messages
+	// from aircraft it has not otherwise heard), and resolves positions.
+	before := r.tracker.Messages()
+	r.tracker.Update("", f, now)
+	if r.tracker.Messages() == before {
+		return Message{}, false
+	}
+	a, _ := r.tracker.Get(mm.ICAO)
+
+	m := Message{
+		HexIdent:     fmt.Sprintf("%06X", mm.ICAO),
+		Timestamp:    now,
+		Callsign:     mm.Callsign,
+		Altitude:     math.NaN(),
+		GroundSpeed:  math.NaN(),
+		Track:        math.NaN(),
+		Latitude:     math.NaN(),
+		Longitude:    math.NaN(),
+		VerticalRate: math.NaN(),
+		Squark:       mm.Squawk,
+		OnGound:      mm.HasOnGround && mm.OnGround,
+	}
+
+	if mm.HasAltitude {
+		m.Altitude = float64(mm.Altitude)
+	}
+	if mm.HasVelocity {
+		m.GroundSpeed, m.Track = mm.GroundSpeed, mm.Track
+	}
+	if mm.HasVerticalRate {
+		m.VerticalRate = float64(mm.VerticalRate)
+	}
+	if mm.HasPosition && a.HasPosition && a.PositionTime.Equal(now) {
+		m.Latitude, m.Longitude = a.Lat, a.Lon
+	}
+
+	switch mm.DF {
+	case 0, 16:
+		m.Type = AitToAir
+	case 4, 20:
+		m.Type = Alt
+		m.Alert, m.Ident = flightStatus(f.Data)
+	case 5, 21:
+		m.Type = ID
+		m.Alert, m.Ident = flightStatus(f.Data)
+		m.Emergency = m.Squark == "7500" || m.Squark == "7600" || m.Squark == "7700"
+	case 11:
+		m.Type = AllCallReply
+	case 17, 18:
+		switch tc := mm.TypeCode; {
+		case tc >= 1 && tc <= 4:
+			m.Type = IdAndCategory
+		case tc >= 5 && tc <= 8:
+			m.Type = SurfacePosition
+		case tc >= 9 && tc <= 18, tc >= 20 && tc <= 22:
+			m.Type = AirbornePosition
+		case tc == 19:
+			m.Type = AirborneVelocity
+		default:
+			return Message{}, false
+		}
+	default:
+		return Message{}, false
+	}
+
+	return m, true
+}
+
+// flightStatus decodes the alert and SPI (ident) conditions from the FS field
+// of DF4/5/20/21.
+func flightStatus(data []byte) (alert bool, ident bool) {
+	switch data[0] & 0x07 {
+	case 2, 3:
+		return true, false
+	case 4:
+		return true, true
+	case 5:
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,137 @@
+package sbs
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"dump1090-proxy/beast"
+	"dump1090-proxy/modes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityReply returns a DF5 reply with the given flight status and (Gillham
+// ordered) identity code.
+func identityReply(icao uint32, fs byte, id uint16) []byte {
+	data := make([]byte, modes.ShortLength)
+	data[0] = 5<<3 | fs
+	data[2], data[3] = byte(id>>8), byte(id)
+	modes.SetParity(data, icao)
+	return data
+}
+
+func beastStream(messages ...[]byte) io.Reader {
+	var buff bytes.Buffer
+	for _, data := range messages {
+		var t byte = beast.ModeSLong
+		if len(data) == modes.ShortLength {
+			t = beast.ModeSShort
+		}
+		buff.Write(beast.Frame{Type: t, Signal: 0x80, Data: data}.Bytes())
+	}
+	return &buff
+}
+
+func readAll(t *testing.T, r *BeastReader) []Message {
+	var messages []Message
+	for {
+		m, err := r.Read()
+		if err == io.EOF {
+			return messages
+		}
+		require.NoError(t, err)
+		messages = append(messages, m)
+	}
+}
+
+func TestBeastReader(t *testing.T) {
+	const icao = 0x40621d
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	r := NewBeastReader(beastStream(
+		modes.EncodeIdentification(icao, "A3", "EZY12A"),
+		modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, false)),
+		modes.EncodeAirbornePosition(icao, 38000, modes.EncodeCPR(52.2572, 3.91937, true)),
+		modes.EncodeVelocity(icao, 450, 90, -640),
+		identityReply(icao, 4, 0xaaa),
+		modes.EncodeAllCall(icao, true),
+	))
+	r.now = func() time.Time { return now }
+
+	messages := readAll(t, r)
+	require.Len(t, messages, 6)
+	for _, m := range messages {
+		assert.Equal(t, "40621D", m.HexIdent)
+		assert.Equal(t, now, m.Timestamp)
+	}
+
+	m := messages[0]
+	assert.Equal(t, IdAndCategory, m.Type)
+	assert.Equal(t, "EZY12A", m.Callsign)
+	assert.True(t, math.IsNaN(m.Altitude))
+	assert.True(t, math.IsNaN(m.Latitude))
+
+	// The position can't be decoded until both halves have been received.
+	m = messages[1]
+	assert.Equal(t, AirbornePosition, m.Type)
+	assert.Equal(t, 38000.0, m.Altitude)
+	assert.True(t, math.IsNaN(m.Latitude))
+	assert.False(t, m.OnGound)
+	m = messages[2]
+	assert.Equal(t, AirbornePosition, m.Type)
+	assert.InDelta(t, 52.2572, m.Latitude, 0.001)
+	assert.InDelta(t, 3.91937, m.Longitude, 0.001)
+
+	m = messages[3]
+	assert.Equal(t, AirborneVelocity, m.Type)
+	assert.InDelta(t, 450, m.GroundSpeed, 1)
+	assert.InDelta(t, 90, m.Track, 1)
+	assert.Equal(t, -640.0, m.VerticalRate)
+	assert.True(t, math.IsNaN(m.Latitude))
+
+	m = messages[4]
+	assert.Equal(t, ID, m.Type)
+	assert.Equal(t, "7700", m.Squark)
+	assert.True(t, m.Emergency)
+	assert.True(t, m.Alert)
+	assert.True(t, m.Ident)
+
+	m = messages[5]
+	assert.Equal(t, AllCallReply, m.Type)
+	assert.True(t, m.OnGound)
+}
+
+func TestBeastReaderSkipsUntrusted(t *testing.T) {
+	damaged := modes.EncodeIdentification(0x40621d, "A3", "EZY12A")
+	damaged[5] ^= 0x10
+
+	r := NewBeastReader(beastStream(
+		// An Address/Parity reply from an aircraft not otherwise heard could
+		// be a damaged message from some other aircraft.
+		identityReply(0x400001, 0, 0),
+		damaged,
+		modes.EncodeAllCall(0x400001, false),
+		identityReply(0x400001, 0, 0),
+	))
+
+	messages := readAll(t, r)
+	require.Len(t, messages, 2)
+	assert.Equal(t, AllCallReply, messages[0].Type)
+	assert.Equal(t, ID, messages[1].Type)
+	assert.False(t, messages[1].Alert)
+	assert.False(t, messages[1].Emergency)
+}
+
+func TestBeastReaderResynchronises(t *testing.T) {
+	stream := io.MultiReader(
+		bytes.NewReader([]byte{0x00, 0x1a}),
+		beastStream(modes.EncodeAllCall(0x400001, false)),
+	)
+
+	messages := readAll(t, NewBeastReader(stream))
+	require.Len(t, messages, 1)
+	assert.Equal(t, "400001", messages[0].HexIdent)
+}
@@ -0,0 +1,92 @@
+package sbs
+
+import (
+	"math"
+	"time"
+)
+
+// Merger combines the fields of messages from the same aircraft. dump1090
+// sends each field only in the message type that carries it (e.g. the
+// callsign in MSG,1 and the ground speed in MSG,4), so positions on their
+// own say little about the aircraft.
+type Merger struct {
+	maxAge     time.Duration
+	aircraft   map[string]*merged
+	nextExpiry time.Time
+}
+
+type merged struct {
+	lastSeen time.Time
+
+	callsign, squawk                 string
+	callsignAt, squawkAt             time.Time
+	groundSpeed, track, verticalRate float64
+	velocityAt, verticalRateAt       time.Time
+}
+
+// NewMerger returns a Merger that fills in fields last received no more than
+// maxAge before the message being merged.
+func NewMerger(maxAge time.Duration) *Merger {
+	return &Merger{
+		maxAge:   maxAge,
+		aircraft: make(map[string]*merged),
+	}
+}
+
+// Merge records the fields present in m, and returns m with its callsign,
+// squawk, ground speed, track and vertical rate filled in from earlier
+// messages if it doesn't carry them itself. Positions are never filled in.
+// now is when the message was sent; callers should use the time it was
+// received for messages whose timestamp is missing.
+func (mg *Merger) Merge(m Message, now time.Time) Message {
+	if now.After(mg.nextExpiry) {
+		mg.expire(now)
+		mg.nextExpiry = now.Add(mg.maxAge)
+	}
+
+	a := mg.aircraft[m.HexIdent]
+	if a == nil {
+		a = &merged{}
+		mg.aircraft[m.HexIdent] = a
+	}
+	a.lastSeen = now
+
+	fresh := func(at time.Time) bool {
+		return !at.IsZero() && now.Sub(at) <= mg.maxAge
+	}
+
+	if m.Callsign != "" {
+		a.callsign, a.callsignAt = m.Callsign, now
+	} else if fresh(a.callsignAt) {
+		m.Callsign = a.callsign
+	}
+
+	if m.Squark != "" {
+		a.squawk, a.squawkAt = m.Squark, now
+	} else if fresh(a.squawkAt) {
+		m.Squark = a.squawk
+	}
+
+	if !math.IsNaN(m.GroundSpeed) && !math.IsNaN(m.Track) {
+		a.groundSpeed, a.track, a.velocityAt = m.GroundSpeed, m.Track, now
+	} else if math.IsNaN(m.GroundSpeed) && math.IsNaN(m.Track) && fresh(a.velocityAt) {
+		m.GroundSpeed, m.Track = a.groundSpeed, a.track
+	}
+
+	if !math.IsNaN(m.VerticalRate) {
+		a.verticalRate, a.verticalRateAt = m.VerticalRate, now
+	} else if fresh(a.verticalRateAt) {
+		m.VerticalRate = a.verticalRate
+	}
+
+	return m
+}
+
+// expire forgets aircraft that have sent nothing for longer than maxAge.
+func (mg *Merger) expire(now time.Time) {
+	for hex, a := range mg.aircraft {
+		if now.Sub(a.lastSeen) > mg.maxAge {
+			delete(mg.aircraft, hex)
+		}
+	}
+}
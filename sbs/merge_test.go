@@ -0,0 +1,108 @@
+package sbs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func message(t MessageType, hex string, at time.Time) Message {
+	nan := math.NaN()
+	return Message{
+		Type:         t,
+		HexIdent:     hex,
+		Timestamp:    at,
+		Altitude:     nan,
+		GroundSpeed:  nan,
+		Track:        nan,
+		Latitude:     nan,
+		Longitude:    nan,
+		VerticalRate: nan,
+	}
+}
+
+func TestMerger(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mg := NewMerger(time.Minute)
+
+	id := message(IdAndCategory, "40621D", start)
+	id.Callsign = "EZY12A"
+	mg.Merge(id, id.Timestamp)
+
+	squawk := message(ID, "40621D", start.Add(time.Second))
+	squawk.Squark = "7700"
+	mg.Merge(squawk, squawk.Timestamp)
+
+	velocity := message(AirborneVelocity, "40621D", start.Add(2*time.Second))
+	velocity.GroundSpeed, velocity.Track, velocity.VerticalRate = 450, 90, -640
+	m := mg.Merge(velocity, velocity.Timestamp)
+	assert.Equal(t, "EZY12A", m.Callsign)
+	assert.True(t, math.IsNaN(m.Latitude))
+
+	// Another aircraft's state is separate.
+	m = mg.Merge(message(AirbornePosition, "400001", start.Add(3*time.Second)), start.Add(3*time.Second))
+	assert.Equal(t, "", m.Callsign)
+	assert.True(t, math.IsNaN(m.GroundSpeed))
+
+	pos := message(AirbornePosition, "40621D", start.Add(3*time.Second))
+	pos.Latitude, pos.Longitude, pos.Altitude = 52.2572, 3.91937, 38000
+	m = mg.Merge(pos, pos.Timestamp)
+	assert.Equal(t, "EZY12A", m.Callsign)
+	assert.Equal(t, "7700", m.Squark)
+	assert.Equal(t, 450.0, m.GroundSpeed)
+	assert.Equal(t, 90.0, m.Track)
+	assert.Equal(t, -640.0, m.VerticalRate)
+	assert.Equal(t, 52.2572, m.Latitude)
+	assert.Equal(t, 38000.0, m.Altitude)
+
+	// Fields in the message itself take precedence.
+	velocity = message(AirborneVelocity, "40621D", start.Add(4*time.Second))
+	velocity.GroundSpeed, velocity.Track, velocity.VerticalRate = 460, 95, 0
+	mg.Merge(velocity, velocity.Timestamp)
+	m = mg.Merge(message(AirbornePosition, "40621D", start.Add(5*time.Second)), start.Add(5*time.Second))
+	assert.Equal(t, 460.0, m.GroundSpeed)
+	assert.Equal(t, 95.0, m.Track)
+	assert.Equal(t, 0.0, m.VerticalRate)
+	// Positions are not carried forward.
+	assert.True(t, math.IsNaN(m.Latitude))
+
+	// Stale fields are not filled in: only the velocity is recent enough.
+	m = mg.Merge(message(AirbornePosition, "40621D", start.Add(63*time.Second)), start.Add(63*time.Second))
+	assert.Equal(t, "", m.Callsign)
+	assert.Equal(t, "", m.Squark)
+	assert.Equal(t, 460.0, m.GroundSpeed)
+}
+
+func TestMergerExpiry(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mg := NewMerger(time.Minute)
+
+	for i := 0; i < 10; i++ {
+		id := message(IdAndCategory, string(rune('A'+i)), start)
+		id.Callsign = "TEST"
+		mg.Merge(id, id.Timestamp)
+	}
+	assert.Len(t, mg.aircraft, 10)
+
+	mg.Merge(message(AllCallReply, "Z", start.Add(2*time.Minute)), start.Add(2*time.Minute))
+	assert.Len(t, mg.aircraft, 1)
+}
+
+func TestMergerWithoutTimestamps(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mg := NewMerger(time.Minute)
+
+	// The messages' timestamps couldn't be parsed, so the times they were
+	// received are used instead.
+	id := message(IdAndCategory, "40621D", time.Time{})
+	id.Callsign = "EZY12A"
+	mg.Merge(id, start)
+
+	m := mg.Merge(message(AirbornePosition, "40621D", time.Time{}), start.Add(time.Second))
+	assert.Equal(t, "EZY12A", m.Callsign)
+
+	m = mg.Merge(message(AirbornePosition, "40621D", time.Time{}), start.Add(2*time.Minute))
+	assert.Equal(t, "", m.Callsign)
+}
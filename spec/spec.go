@@ -0,0 +1,157 @@
+// Package spec parses command-line values of the form
+// "name?key=value&key=value", used to attach options to an individual
+// listener, remote or writer.
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spec holds the options given with a name. Values for the same key may be
+// repeated, or separated by commas. Options are recorded as they are read,
+// so that Check can report any that weren't expected.
+type Spec struct {
+	// Name is the part before the "?", e.g. an address or kind of writer.
+	Name string
+
+	values url.Values
+	used   map[string]bool
+	err    error
+}
+
+// Parse splits s into its name and options.
+func Parse(s string) (*Spec, error) {
+	name, query := s, ""
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		name, query = s[:i], s[i+1:]
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", s, err)
+	}
+
+	return New(name, values), nil
+}
+
+// New returns a Spec holding values already parsed, e.g. from the query of a
+// request.
+func New(name string, values url.Values) *Spec {
+	return &Spec{
+		Name:   name,
+		values: values,
+		used:   make(map[string]bool),
+	}
+}
+
+// Has reports whether the option was given.
+func (s *Spec) Has(key string) bool {
+	s.used[key] = true
+	_, ok := s.values[key]
+	return ok
+}
+
+// String returns the last value given for key, or def if there is none.
+func (s *Spec) String(key string, def string) string {
+	s.used[key] = true
+	if v, ok := s.values[key]; ok {
+		return v[len(v)-1]
+	}
+	return def
+}
+
+// Strings returns all values given for key, without splitting them.
+func (s *Spec) Strings(key string) []string {
+	s.used[key] = true
+	return s.values[key]
+}
+
+// List returns all values given for key, splitting comma-separated values.
+func (s *Spec) List(key string) []string {
+	s.used[key] = true
+	var l []string
+	for _, v := range s.values[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				l = append(l, item)
+			}
+		}
+	}
+	return l
+}
+
+// Bool, Int and Float return def if the option is not given. Values that
+// can't be parsed are reported by Check.
+func (s *Spec) Bool(key string, def bool) bool {
+	v := s.String(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.Fail(key, err)
+	}
+	return b
+}
+
+func (s *Spec) Int(key string, def int) int {
+	v := s.String(key, "")
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		s.Fail(key, err)
+	}
+	return i
+}
+
+func (s *Spec) Float(key string, def float64) float64 {
+	v := s.String(key, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		s.Fail(key, err)
+	}
+	return f
+}
+
+// Fail records that an option's value is invalid, to be reported by Check.
+func (s *Spec) Fail(key string, err error) {
+	if s.err == nil {
+		s.err = s.OptionError(key, err)
+	}
+}
+
+// OptionError returns err as an error in the value of option key.
+func (s *Spec) OptionError(key string, err error) error {
+	return fmt.Errorf("%s: option %q: %w", s.Name, key, err)
+}
+
+// Check returns the first error found while reading options, or an error
+// naming any options that were given but never read.
+func (s *Spec) Check() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	var unknown []string
+	for key := range s.values {
+		if !s.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%s: unknown option(s) %s", s.Name, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package spec
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("127.0.0.1:30005?tls=true&retries=3&gain=1.5&allow=a,b&allow=c&token=x,y&name=one&name=two")
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1:30005", s.Name)
+	assert.True(t, s.Has("tls"))
+	assert.False(t, s.Has("compress"))
+	assert.True(t, s.Bool("tls", false))
+	assert.Equal(t, 3, s.Int("retries", 1))
+	assert.Equal(t, 1.5, s.Float("gain", 0))
+	assert.Equal(t, []string{"a", "b", "c"}, s.List("allow"))
+	assert.Equal(t, []string{"x,y"}, s.Strings("token"))
+	// The last value wins.
+	assert.Equal(t, "two", s.String("name", ""))
+	assert.NoError(t, s.Check())
+
+	// Options not given take their defaults.
+	s, err = Parse("csv")
+	require.NoError(t, err)
+	assert.Equal(t, "csv", s.Name)
+	assert.Equal(t, "def", s.String("dir", "def"))
+	assert.True(t, s.Bool("tls", true))
+	assert.Equal(t, 7, s.Int("retries", 7))
+	assert.Nil(t, s.List("allow"))
+	assert.NoError(t, s.Check())
+
+	_, err = Parse("csv?%zz")
+	assert.ErrorContains(t, err, "invalid URL escape")
+}
+
+func TestCheck(t *testing.T) {
+	// Options never read are unknown.
+	s, err := Parse("jsonl?columns=hex&size=1&dir=/tmp")
+	require.NoError(t, err)
+	s.String("dir", "")
+	assert.EqualError(t, s.Check(), "jsonl: unknown option(s) columns, size")
+
+	// The first invalid value is reported, before unknown options.
+	s = New("frames", url.Values{"tls": {"maybe"}, "retries": {"x"}, "colour": {"red"}})
+	s.Bool("tls", false)
+	s.Int("retries", 0)
+	assert.EqualError(t, s.Check(), `frames: option "tls": strconv.ParseBool: parsing "maybe": invalid syntax`)
+
+	s = New("frames", nil)
+	assert.NoError(t, s.Check())
+	assert.EqualError(t, s.OptionError("columns", assert.AnError), `frames: option "columns": `+assert.AnError.Error())
+}